@@ -0,0 +1,188 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto/subtle"
+	"cybero/types"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// APIKeyHeader the header used to present an API key
+	APIKeyHeader = "X-API-Key"
+	// APIKeyPrefix the prefix of the API keys issued by the server
+	APIKeyPrefix = "cyb"
+	// APIKeyProvider the provider recorded in the claims of API keys
+	APIKeyProvider = "apikey"
+
+	errAPIKeyInvalid = errors.New("invalid API key")
+	errAPIKeyExpired = errors.New("API key expired")
+)
+
+// apiKeyStore holds the issued API keys, keys have the format
+// "cyb_<id>_<secret>" and only their hash is stored
+type apiKeyStore struct {
+	sync.Mutex
+	keys map[string]*types.CyberoAPIKey
+	file string
+}
+
+func newAPIKeyStore(file string) *apiKeyStore {
+	return &apiKeyStore{keys: make(map[string]*types.CyberoAPIKey), file: file}
+}
+
+// load read the API keys from the store file
+func (store *apiKeyStore) load() error {
+
+	if store.file == "" {
+		return nil
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	return readJSONFile(store.file, &store.keys)
+}
+
+// save write the API keys to the store file, must be called locked
+func (store *apiKeyStore) save() error {
+
+	if store.file == "" {
+		return nil
+	}
+
+	return writeJSONFile(store.file, store.keys, 0600)
+}
+
+// create issue a new API key, the key is only returned here
+func (store *apiKeyStore) create(name string, owner string, scopes []string, expires int64) (string, types.CyberoAPIKey, error) {
+
+	id, err := randomString(6)
+	if err != nil {
+		return "", types.CyberoAPIKey{}, err
+	}
+
+	// The separator can not be part of the id
+	id = strings.ReplaceAll(id, "_", "-")
+
+	secret, err := randomString(32)
+	if err != nil {
+		return "", types.CyberoAPIKey{}, err
+	}
+
+	key := APIKeyPrefix + "_" + id + "_" + secret
+	entry := &types.CyberoAPIKey{
+		ID:      id,
+		Name:    name,
+		Owner:   owner,
+		Scopes:  scopes,
+		Created: time.Now().Unix(),
+		Expires: expires,
+		Hash:    hashToken(key),
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	if _, ok := store.keys[id]; ok {
+		return "", types.CyberoAPIKey{}, errors.New("duplicated API key id")
+	}
+
+	store.keys[id] = entry
+
+	if err := store.save(); err != nil {
+		delete(store.keys, id)
+		return "", types.CyberoAPIKey{}, err
+	}
+
+	info := *entry
+	info.Hash = ""
+	return key, info, nil
+}
+
+// list returns the keys of an owner, or every key if owner is empty
+func (store *apiKeyStore) list(owner string) []types.CyberoAPIKey {
+
+	store.Lock()
+	defer store.Unlock()
+
+	keys := []types.CyberoAPIKey{}
+
+	for _, entry := range store.keys {
+		if owner == "" || entry.Owner == owner {
+			info := *entry
+			info.Hash = ""
+			keys = append(keys, info)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created < keys[j].Created })
+	return keys
+}
+
+// get returns a key by id
+func (store *apiKeyStore) get(id string) (types.CyberoAPIKey, bool) {
+
+	store.Lock()
+	defer store.Unlock()
+
+	entry, ok := store.keys[id]
+	if !ok {
+		return types.CyberoAPIKey{}, false
+	}
+
+	return *entry, true
+}
+
+// revoke removes a key
+func (store *apiKeyStore) revoke(id string) error {
+
+	store.Lock()
+	defer store.Unlock()
+
+	if _, ok := store.keys[id]; !ok {
+		return errAPIKeyInvalid
+	}
+
+	delete(store.keys, id)
+	return store.save()
+}
+
+// verify returns the key entry matching a presented key
+func (store *apiKeyStore) verify(key string) (types.CyberoAPIKey, error) {
+
+	parts := strings.SplitN(key, "_", 3)
+
+	if len(parts) != 3 || parts[0] != APIKeyPrefix {
+		return types.CyberoAPIKey{}, errAPIKeyInvalid
+	}
+
+	entry, ok := store.get(parts[1])
+
+	if !ok || subtle.ConstantTimeCompare([]byte(entry.Hash), []byte(hashToken(key))) != 1 {
+		return types.CyberoAPIKey{}, errAPIKeyInvalid
+	}
+
+	if entry.Expires > 0 && time.Now().Unix() > entry.Expires {
+		return types.CyberoAPIKey{}, errAPIKeyExpired
+	}
+
+	return entry, nil
+}
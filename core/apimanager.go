@@ -15,11 +15,15 @@
 package core
 
 import (
+	"context"
 	"cybero/types"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"runtime/debug"
 	"strings"
 	"sync"
 )
@@ -32,22 +36,27 @@ type APIManager struct {
 var (
 	// APIEndpoint the api endpoint
 	APIEndpoint = "api"
-	apiSync     sync.Once
-	api         *APIManager
+	// BuiltinModule the name of the builtin module
+	BuiltinModule = "builtin"
+	apiSync       sync.Once
+	api           *APIManager
 )
 
 func listAction(w http.ResponseWriter, r *http.Request) error {
 
 	modules := []map[string]interface{}{
-		map[string]interface{}{"name": "builtin", "version": "-"},
+		map[string]interface{}{"name": BuiltinModule, "version": "-"},
 	}
 
-	for _, moduleImpl := range GetModuleManager().GetAPIModules() {
+	for endpoint, moduleImpl := range GetModuleManager().GetAPIModules() {
 		module := moduleImpl.(types.CyberoHandlerModule)
+		name, _ := GetModuleManager().GetAPIModuleName(endpoint)
 		modules = append(modules, map[string]interface{}{
 			"name":     module.Name(),
 			"version":  module.Version(),
-			"endpoint": module.Endpoint(),
+			"endpoint": endpoint,
+			"enabled":  !GetModuleManager().IsDisabled(name),
+			"versions": GetModuleManager().versionMatrix(endpoint),
 		})
 	}
 
@@ -83,6 +92,88 @@ func infoAction(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+func reloadAction(w http.ResponseWriter, r *http.Request) error {
+
+	if r.Method != http.MethodPost {
+		sendResponse(w, http.StatusMethodNotAllowed, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, method not allowed %q\n", r.Method)})
+		return nil
+	}
+
+	reloaded := GetModuleManager().ReloadModules()
+
+	sendResponse(w, http.StatusOK, 0, map[string]interface{}{"Reloaded": reloaded})
+	return nil
+}
+
+// moduleAdminAction run an admin operation on the module given in the query
+// and persist its configuration if asked to
+func moduleAdminAction(w http.ResponseWriter, r *http.Request, operation func(string) error) error {
+
+	if r.Method != http.MethodPost {
+		sendResponse(w, http.StatusMethodNotAllowed, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, method not allowed %q\n", r.Method)})
+		return nil
+	}
+
+	module := r.URL.Query().Get("module")
+
+	if err := operation(module); err != nil {
+		sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, %v\n", err)})
+		return nil
+	}
+
+	if r.URL.Query().Get("persist") == "true" {
+		if err := GetConfigManager().saveModuleConfig(module); err != nil {
+			sendResponse(w, http.StatusInternalServerError, -1, map[string]interface{}{"Error": fmt.Sprintf("Error saving the configuration: %v\n", err)})
+			return nil
+		}
+	}
+
+	sendResponse(w, http.StatusOK, 0, map[string]interface{}{"Module": module, "Enabled": !GetModuleManager().IsDisabled(module)})
+	return nil
+}
+
+func enableAction(w http.ResponseWriter, r *http.Request) error {
+	return moduleAdminAction(w, r, GetModuleManager().EnableModule)
+}
+
+func disableAction(w http.ResponseWriter, r *http.Request) error {
+	return moduleAdminAction(w, r, GetModuleManager().DisableModule)
+}
+
+func reinitAction(w http.ResponseWriter, r *http.Request) error {
+
+	return moduleAdminAction(w, r, func(module string) error {
+
+		// The body, if any, is the new module configuration
+		var config map[string]interface{}
+
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil && err != io.EOF {
+			return fmt.Errorf("invalid configuration: %v", err)
+		}
+
+		return GetModuleManager().ReinitializeModule(module, config)
+	})
+}
+
+func healthAction(w http.ResponseWriter, r *http.Request) error {
+
+	status, code := http.StatusOK, 0
+	modules := map[string]interface{}{}
+
+	for name, err := range GetModuleManager().Health() {
+
+		modules[name] = "ok"
+
+		if err != nil {
+			status, code = http.StatusServiceUnavailable, -1
+			modules[name] = err.Error()
+		}
+	}
+
+	sendResponse(w, status, code, map[string]interface{}{"Health": modules})
+	return nil
+}
+
 func helpAction(w http.ResponseWriter, r *http.Request) error {
 
 	encoder := json.NewEncoder(w)
@@ -101,6 +192,26 @@ func helpAction(w http.ResponseWriter, r *http.Request) error {
 			code, msg = 0, map[string]interface{}{"Help": "Returns information about a specific module"}
 		}
 
+		if action == "health" {
+			code, msg = 0, map[string]interface{}{"Help": "Returns the health of the loaded modules"}
+		}
+
+		if action == "enable" {
+			code, msg = 0, map[string]interface{}{"Help": "Serves a disabled module again, persist=true saves the configuration"}
+		}
+
+		if action == "disable" {
+			code, msg = 0, map[string]interface{}{"Help": "Stops serving a module keeping it loaded, persist=true saves the configuration"}
+		}
+
+		if action == "reinit" {
+			code, msg = 0, map[string]interface{}{"Help": "Initializes a module again, the body is the new configuration"}
+		}
+
+		if action == "reload" {
+			code, msg = 0, map[string]interface{}{"Help": "Loads new modules and new versions of loaded modules"}
+		}
+
 	} else if module, ok := GetModuleManager().GetAPIModule(module); ok {
 		code, msg = 0, map[string]interface{}{"Help": module.Help(action)}
 	}
@@ -113,6 +224,94 @@ func helpAction(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// authenticate verify the request credentials unless the module is public,
+// the claims are made available to the module in the request context
+func (api *APIManager) authenticate(w http.ResponseWriter, r *http.Request, name string) (*http.Request, bool) {
+
+	logger := GetLogManager().GetLogger()
+
+	claims, err := GetAuthManager().VerifyRequest(r)
+
+	if err == nil {
+		return r.WithContext(context.WithValue(r.Context(), types.CyberoClaimsKey, claims)), true
+	}
+
+	if config, ok := GetConfigManager().moduleConfig(name); ok && config.Public {
+		return r, true
+	}
+
+	logger.Printf("API: Unauthorized request to module %q: %v\n", name, err)
+	w.Header().Set("WWW-Authenticate", `Bearer realm="cybero"`)
+	sendResponse(w, http.StatusUnauthorized, -1, map[string]interface{}{"Error": "Error, authentication required\n"})
+
+	return r, false
+}
+
+// authorize check the authenticated claims hold the scopes required by the
+// module action
+func (api *APIManager) authorize(w http.ResponseWriter, r *http.Request, name string, action string) bool {
+
+	logger := GetLogManager().GetLogger()
+
+	claims, _ := types.GetClaims(r.Context())
+
+	if hasScopes(claims, requiredScopes(name, action)) {
+		return true
+	}
+
+	username := ""
+	if claims != nil {
+		username = claims.Username
+	}
+
+	logger.Printf("API: User %q not authorized to call %q of module %q\n", username, action, name)
+	sendResponse(w, http.StatusForbidden, -1, map[string]interface{}{"Error": "Error, not authorized\n"})
+
+	return false
+}
+
+// callModule pass the request to a module, a panic is recovered and
+// answered with an error. Panics, 5xx responses and errors returned without
+// a response count as breaker failures
+func (api *APIManager) callModule(w http.ResponseWriter, r *http.Request, handle *moduleHandle) (err error) {
+
+	logger := GetLogManager().GetLogger()
+
+	module := handle.module.(types.CyberoHandlerModule)
+	tracker := &trackingWriter{ResponseWriter: w}
+
+	defer func() {
+
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		handle.breaker.failure()
+		logger.Printf("API: module %q panic: %v\n%s", handle.key, recovered, debug.Stack())
+
+		// Nothing sensible can be sent once the module started its response
+		if tracker.written {
+			panic(http.ErrAbortHandler)
+		}
+
+		sendResponse(w, http.StatusInternalServerError, -1, map[string]interface{}{"Error": "Error, module failed processing the request\n"})
+		err = nil
+	}()
+
+	err = module.HandleRequest(tracker, r)
+
+	// Only server side failures count, not rejected requests. An error
+	// without a response is answered with a generic error
+	if tracker.failed() || (err != nil && !tracker.written) {
+		handle.breaker.failure()
+	} else {
+		handle.breaker.success()
+	}
+
+	return err
+}
+
 // HandleRequest pass the request to an external module
 func (api *APIManager) HandleRequest(w http.ResponseWriter, r *http.Request) error {
 
@@ -137,19 +336,90 @@ func (api *APIManager) HandleRequest(w http.ResponseWriter, r *http.Request) err
 
 	// Check if it is an internal action
 	if action, ok := api.apiActions[parts[0]]; ok {
+
+		r, ok = api.authenticate(w, r, BuiltinModule)
+		if !ok || !api.authorize(w, r, BuiltinModule, parts[0]) {
+			return nil
+		}
+
 		logger.Printf("API: builtin action called %q", parts[0])
 		return action(w, r)
 	}
 
+	// A version is selected with /api/vN/module or the version header
+	version := r.Header.Get(APIVersionHeader)
+
+	if _, ok := parseAPIVersion(parts[0]); ok && strings.HasPrefix(parts[0], "v") && len(parts) > 1 {
+		version, parts = parts[0], parts[1:]
+
+		// Modules see the path without the version
+		stripped := *r.URL
+		stripped.Path = endpoint + "/" + strings.Join(parts, "/")
+		stripped.RawPath = ""
+
+		r = r.WithContext(r.Context())
+		r.URL = &stripped
+	}
+
 	// Check if is an action related to a module
-	if module, ok := GetModuleManager().GetAPIModule(parts[0]); ok {
+	route, err := GetModuleManager().acquireAPIModule(parts[0], version)
+
+	if err == errVersionNotFound {
+		sendResponse(w, http.StatusNotFound, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, version %q of module %q not available\n", version, parts[0])})
+		return nil
+	}
+
+	if err == nil {
+
+		defer route.release()
+
+		module, name := route.handle.module.(types.CyberoHandlerModule), route.handle.name
+
+		// Only actions the module declares have their own policy
+		action := ""
+		if len(parts) > 1 {
+			if _, ok := module.Actions()[parts[1]]; ok {
+				action = parts[1]
+			}
+		}
+
+		r, ok := api.authenticate(w, r, name)
+		if !ok || !api.authorize(w, r, name, action) {
+			return nil
+		}
+
+		if route.disabled {
+			sendResponse(w, http.StatusServiceUnavailable, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, module %q is disabled\n", name)})
+			return nil
+		}
+
+		w.Header().Set(APIVersionHeader, route.handle.version)
+
+		// Older versions point to the newest one
+		if route.successor != "" {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf("<%s/%s/%s>; rel=\"successor-version\"", endpoint, route.successor, route.endpoint))
+		}
+
+		if ok, wait := route.handle.breaker.allow(); !ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(wait.Seconds())))
+			sendResponse(w, http.StatusServiceUnavailable, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, module %q is failing, try again later\n", name)})
+			return nil
+		}
+
 		logger.Printf("API: module %q called", parts[0])
-		return module.HandleRequest(w, r)
+		return api.callModule(w, r, route.handle)
 	}
 
 	return errors.New("Invalid operation")
 }
 
+// isBuiltinAction returns true if name is a builtin action
+func isBuiltinAction(name string) bool {
+	_, ok := GetAPIManager().apiActions[name]
+	return ok
+}
+
 // GetAPIManager Initialize modules compoment
 func GetAPIManager() *APIManager {
 
@@ -162,9 +432,14 @@ func GetAPIManager() *APIManager {
 
 		// Setup API actions callbacks
 		api.apiActions = map[string]types.CyberoHandler{
-			"list": listAction,
-			"info": infoAction,
-			"help": helpAction,
+			"list":    listAction,
+			"info":    infoAction,
+			"help":    helpAction,
+			"reload":  reloadAction,
+			"health":  healthAction,
+			"enable":  enableAction,
+			"disable": disableAction,
+			"reinit":  reinitAction,
 		}
 	})
 
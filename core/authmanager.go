@@ -15,33 +15,654 @@
 package core
 
 import (
+	"crypto/x509"
 	"cybero/types"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // AuthManager the server security auth
 type AuthManager struct {
-	authActions map[string]types.CyberoHandler
+	authActions   map[string]types.CyberoHandler
+	refreshTokens *refreshTokenStore
+	revoked       *revocationStore
+	keys          *keyring
+	keysErr       error
+	apiKeys       *apiKeyStore
+	oidc          *oidcValidator
+	lockout       *lockoutTracker
 }
 
 var (
 	// AuthEndpoint the auth endpoint
 	AuthEndpoint = "auth"
-	authSync     sync.Once
-	auth         *AuthManager
+	// CertificateProvider the provider recorded in the claims of client certificates
+	CertificateProvider = "mtls"
+	authSync            sync.Once
+	auth                *AuthManager
+
+	collectInterval = time.Minute
+
+	errNoAuthProvider = errors.New("no authentication provider available")
+	errAuthFailed     = errors.New("authentication failed")
 )
 
+// authProviders returns the configured chain of authentication providers
+func authProviders() []string {
+
+	cfg := GetConfigManager().serverConfig()
+
+	if len(cfg.Auth.Providers) > 0 {
+		return cfg.Auth.Providers
+	}
+
+	if cfg.Auth.Provider != "" {
+		return []string{cfg.Auth.Provider}
+	}
+
+	return nil
+}
+
+// authenticate try each provider of the chain in turn, returning the
+// first provider accepting the credentials
+func (auth *AuthManager) authenticate(credentials *types.CyberoCredentials, chain []string) (types.CyberoAuthModule, string, error) {
+
+	logger := GetLogManager().GetLogger()
+	available := false
+
+	for _, name := range chain {
+
+		provider, ok := GetModuleManager().GetAuthModule(name)
+
+		if !ok {
+			logger.Printf("Auth: Authentication provider %q not available\n", name)
+			continue
+		}
+
+		available = true
+
+		if provider.Authenticate(credentials) {
+			return provider, name, nil
+		}
+
+		logger.Printf("Auth: Provider %q rejected user %q\n", name, credentials.Username)
+	}
+
+	if !available {
+		return nil, "", errNoAuthProvider
+	}
+
+	return nil, "", errAuthFailed
+}
+
 func (auth *AuthManager) signinAction(w http.ResponseWriter, r *http.Request) error {
+
+	logger := GetLogManager().GetLogger()
+
+	if r.Method != http.MethodPost {
+		sendResponse(w, http.StatusMethodNotAllowed, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, method not allowed %q\n", r.Method)})
+		return nil
+	}
+
+	var credentials types.CyberoCredentials
+
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		logger.Printf("Auth: Error decoding credentials: %v\n", err)
+		sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": "Error, invalid credentials\n"})
+		return nil
+	}
+
+	// Refuse attempts while the username or address is backing off
+	userKey, addressKey := userLockoutKey(credentials.Username), addressLockoutKey(remoteHost(r))
+
+	if wait := auth.lockout.check(userKey, addressKey); wait > 0 {
+		logger.Printf("Auth: Signin of user %q from %q throttled for %v\n", credentials.Username, r.RemoteAddr, wait)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		sendResponse(w, http.StatusTooManyRequests, -1, map[string]interface{}{"Error": "Error, too many failed attempts, try again later\n"})
+		return nil
+	}
+
+	defer auth.lockout.release(userKey, addressKey)
+
+	// A provider hint restricts the chain to that provider
+	chain := authProviders()
+
+	if credentials.Provider != "" {
+
+		found := false
+		for _, name := range chain {
+			if name == credentials.Provider {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			logger.Printf("Auth: Authentication provider %q not in the providers chain\n", credentials.Provider)
+			sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, unknown authentication provider %q\n", credentials.Provider)})
+			return nil
+		}
+
+		chain = []string{credentials.Provider}
+	}
+
+	provider, providerName, err := auth.authenticate(&credentials, chain)
+
+	if err == errNoAuthProvider {
+		sendResponse(w, http.StatusServiceUnavailable, -1, map[string]interface{}{"Error": "Error, authentication provider not available\n"})
+		return nil
+	}
+
+	if err != nil {
+
+		logger.Printf("Auth: Authentication failed for user %q\n", credentials.Username)
+
+		for _, key := range auth.lockout.fail(userKey, addressKey) {
+			failures, until := auth.lockout.failures(key)
+			logger.Printf("Auth: event=lockout key=%q failures=%d until=%q username=%q address=%q\n",
+				key, failures, until.Format(time.RFC3339), credentials.Username, r.RemoteAddr)
+		}
+
+		sendResponse(w, http.StatusUnauthorized, -1, map[string]interface{}{"Error": "Error, invalid username or password\n"})
+		return nil
+	}
+
+	auth.lockout.reset(userKey)
+
+	claims := &types.CyberoClaims{Username: credentials.Username, Provider: providerName}
+
+	// Grant the roles known by the provider plus the configured ones
+	var roles []string
+	if rolesProvider, ok := provider.(types.CyberoAuthRolesModule); ok {
+		roles = rolesProvider.Roles(credentials.Username)
+	}
+	grantClaims(claims, roles)
+
+	tokenString, expirationTime, err := auth.issueToken(claims)
+
+	if err != nil {
+		logger.Printf("Auth: Error signing token for user %q: %v\n", credentials.Username, err)
+		sendResponse(w, http.StatusInternalServerError, -1, map[string]interface{}{"Error": "Error, unable to issue token\n"})
+		return nil
+	}
+
+	refreshToken, refreshExpirationTime, err := auth.refreshTokens.issue(*claims, "")
+
+	if err != nil {
+		logger.Printf("Auth: Error issuing refresh token for user %q: %v\n", credentials.Username, err)
+		sendResponse(w, http.StatusInternalServerError, -1, map[string]interface{}{"Error": "Error, unable to issue token\n"})
+		return nil
+	}
+
+	logger.Printf("Auth: User %q signed in using provider %q\n", credentials.Username, providerName)
+	sendResponse(w, http.StatusOK, 0, map[string]interface{}{
+		"Token":          tokenString,
+		"Expires":        expirationTime.Unix(),
+		"RefreshToken":   refreshToken,
+		"RefreshExpires": refreshExpirationTime.Unix(),
+	})
+
 	return nil
 }
 
 func (auth *AuthManager) refreshAction(w http.ResponseWriter, r *http.Request) error {
+
+	logger := GetLogManager().GetLogger()
+
+	if r.Method != http.MethodPost {
+		sendResponse(w, http.StatusMethodNotAllowed, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, method not allowed %q\n", r.Method)})
+		return nil
+	}
+
+	var request types.CyberoRefreshRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		logger.Printf("Auth: Error decoding refresh request: %v\n", err)
+		sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": "Error, invalid refresh request\n"})
+		return nil
+	}
+
+	// A refresh token was given, rotate it and issue a new token
+	if request.RefreshToken != "" {
+
+		claims, refreshToken, refreshExpirationTime, err := auth.refreshTokens.rotate(request.RefreshToken)
+
+		if err == errRefreshTokenReplayed {
+			logger.Printf("Auth: Refresh token replayed for user %q, revoking all tokens of the session\n", claims.Username)
+		}
+
+		if err != nil {
+			logger.Printf("Auth: Error refreshing token: %v\n", err)
+			sendResponse(w, http.StatusUnauthorized, -1, map[string]interface{}{"Error": "Error, invalid refresh token\n"})
+			return nil
+		}
+
+		tokenString, expirationTime, err := auth.issueToken(&claims)
+
+		if err != nil {
+			logger.Printf("Auth: Error signing token for user %q: %v\n", claims.Username, err)
+			sendResponse(w, http.StatusInternalServerError, -1, map[string]interface{}{"Error": "Error, unable to issue token\n"})
+			return nil
+		}
+
+		auth.refreshTokens.track(refreshToken, claims)
+
+		logger.Printf("Auth: Refresh token rotated for user %q\n", claims.Username)
+		sendResponse(w, http.StatusOK, 0, map[string]interface{}{
+			"Token":          tokenString,
+			"Expires":        expirationTime.Unix(),
+			"RefreshToken":   refreshToken,
+			"RefreshExpires": refreshExpirationTime.Unix(),
+		})
+
+		return nil
+	}
+
+	// Otherwise slide the expiry of the current token, expired tokens are
+	// accepted within the grace window
+	claims, err := auth.parseToken(bearerToken(r))
+
+	if err != nil && (!isExpiredOnly(err) || time.Since(time.Unix(claims.ExpiresAt, 0)) > refreshGrace()) {
+		logger.Printf("Auth: Error refreshing token: %v\n", err)
+		sendResponse(w, http.StatusUnauthorized, -1, map[string]interface{}{"Error": "Error, invalid token\n"})
+		return nil
+	}
+
+	// The replaced token can not be refreshed or used again, it is kept
+	// revoked for as long as the grace window allows refreshing it. Only
+	// one of concurrent refreshes of a token succeeds
+	revokeUntil := time.Unix(claims.ExpiresAt, 0).Add(refreshGrace()).Unix()
+
+	revoked, err := auth.revoked.revokeIfAbsent(claims.Id, revokeUntil)
+
+	if err != nil {
+		logger.Printf("Auth: Error persisting revoked token %q: %v\n", claims.Id, err)
+	}
+
+	if !revoked {
+		logger.Printf("Auth: Error refreshing token: token %q was revoked\n", claims.Id)
+		sendResponse(w, http.StatusUnauthorized, -1, map[string]interface{}{"Error": "Error, invalid token\n"})
+		return nil
+	}
+
+	replacedID := claims.Id
+	tokenString, expirationTime, err := auth.issueToken(claims)
+
+	if err != nil {
+		logger.Printf("Auth: Error signing token for user %q: %v\n", claims.Username, err)
+		sendResponse(w, http.StatusInternalServerError, -1, map[string]interface{}{"Error": "Error, unable to issue token\n"})
+		return nil
+	}
+
+	auth.refreshTokens.replace(replacedID, *claims)
+
+	logger.Printf("Auth: Token refreshed for user %q\n", claims.Username)
+	sendResponse(w, http.StatusOK, 0, map[string]interface{}{
+		"Token":   tokenString,
+		"Expires": expirationTime.Unix(),
+	})
+
+	return nil
+}
+
+func (auth *AuthManager) signoutAction(w http.ResponseWriter, r *http.Request) error {
+
+	logger := GetLogManager().GetLogger()
+
+	if r.Method != http.MethodPost {
+		sendResponse(w, http.StatusMethodNotAllowed, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, method not allowed %q\n", r.Method)})
+		return nil
+	}
+
+	claims, err := auth.VerifyRequest(r)
+
+	if err != nil {
+		logger.Printf("Auth: Error signing out: %v\n", err)
+		sendResponse(w, http.StatusUnauthorized, -1, map[string]interface{}{"Error": "Error, invalid token\n"})
+		return nil
+	}
+
+	if claims.Provider == APIKeyProvider {
+		sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": "Error, API keys are revoked through the keys actions\n"})
+		return nil
+	}
+
+	// Certificates and peer credentials have no token to revoke
+	if claims.Id == "" {
+		sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": "Error, only tokens can be signed out\n"})
+		return nil
+	}
+
+	// The refresh token of the session is optional
+	var request types.CyberoRefreshRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		logger.Printf("Auth: Error decoding signout request: %v\n", err)
+		sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": "Error, invalid signout request\n"})
+		return nil
+	}
+
+	if request.RefreshToken != "" {
+		auth.refreshTokens.revoke(request.RefreshToken)
+	}
+
+	// Expired tokens can still be refreshed within the grace window
+	revokeUntil := time.Unix(claims.ExpiresAt, 0).Add(refreshGrace()).Unix()
+
+	if err := auth.revoked.revoke(claims.Id, revokeUntil); err != nil {
+		logger.Printf("Auth: Error persisting revoked token %q: %v\n", claims.Id, err)
+	}
+
+	logger.Printf("Auth: User %q signed out\n", claims.Username)
+	sendResponse(w, http.StatusOK, 0, map[string]interface{}{"Info": "Signed out\n"})
+
+	return nil
+}
+
+// jwksAction publish the public keys used to sign tokens, the key set is
+// returned as is so standard JWKS clients can consume it
+func (auth *AuthManager) jwksAction(w http.ResponseWriter, r *http.Request) error {
+
+	set := jsonWebKeySet{Keys: []jsonWebKey{}}
+
+	if auth.keys != nil {
+		set = auth.keys.jwks()
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	return json.NewEncoder(w).Encode(set)
+}
+
+// keysAction manage the API keys, users manage their own keys while
+// administrators manage every key
+func (auth *AuthManager) keysAction(w http.ResponseWriter, r *http.Request) error {
+
+	logger := GetLogManager().GetLogger()
+
+	claims, err := auth.VerifyRequest(r)
+
+	if err != nil {
+		logger.Printf("Auth: Unauthorized request to manage API keys: %v\n", err)
+		sendResponse(w, http.StatusUnauthorized, -1, map[string]interface{}{"Error": "Error, authentication required\n"})
+		return nil
+	}
+
+	// remove /auth/keys/ from url and split
+	parts := strings.Split(r.URL.Path[len(AuthEndpoint)+2:], "/")
+	operation := ""
+
+	if len(parts) > 1 {
+		operation = parts[1]
+	}
+
+	admin := hasScopes(claims, []string{ScopeAuthAdmin})
+
+	switch operation {
+	case "create":
+		auth.createKey(w, r, claims, admin)
+	case "list":
+		auth.listKeys(w, r, claims, admin)
+	case "revoke":
+		auth.revokeKey(w, r, claims, admin)
+	default:
+		sendResponse(w, http.StatusNotFound, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, unknown operation %q\n", operation)})
+	}
+
+	return nil
+}
+
+func (auth *AuthManager) createKey(w http.ResponseWriter, r *http.Request, claims *types.CyberoClaims, admin bool) {
+
+	logger := GetLogManager().GetLogger()
+
+	if r.Method != http.MethodPost {
+		sendResponse(w, http.StatusMethodNotAllowed, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, method not allowed %q\n", r.Method)})
+		return
+	}
+
+	// A key could otherwise outlive itself through a replacement
+	if claims.Provider == APIKeyProvider {
+		sendResponse(w, http.StatusForbidden, -1, map[string]interface{}{"Error": "Error, API keys can not create API keys\n"})
+		return
+	}
+
+	var request types.CyberoAPIKeyRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Name == "" {
+		sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": "Error, invalid API key request\n"})
+		return
+	}
+
+	if request.Expires != 0 && request.Expires <= time.Now().Unix() {
+		sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": "Error, API key expiry is in the past\n"})
+		return
+	}
+
+	owner := claims.Username
+
+	if request.Owner != "" && request.Owner != owner {
+
+		if !admin {
+			sendResponse(w, http.StatusForbidden, -1, map[string]interface{}{"Error": "Error, not authorized\n"})
+			return
+		}
+
+		owner = request.Owner
+	}
+
+	// Users can not give a key more than they have
+	if !admin && !hasScopes(claims, request.Scopes) {
+		sendResponse(w, http.StatusForbidden, -1, map[string]interface{}{"Error": "Error, not authorized to grant the requested scopes\n"})
+		return
+	}
+
+	key, info, err := auth.apiKeys.create(request.Name, owner, request.Scopes, request.Expires)
+
+	if err != nil {
+		logger.Printf("Auth: Error creating API key for user %q: %v\n", owner, err)
+		sendResponse(w, http.StatusInternalServerError, -1, map[string]interface{}{"Error": "Error, unable to create API key\n"})
+		return
+	}
+
+	logger.Printf("Auth: API key %q created for user %q by %q\n", info.ID, owner, claims.Username)
+	sendResponse(w, http.StatusOK, 0, map[string]interface{}{"Key": key, "APIKey": info})
+}
+
+func (auth *AuthManager) listKeys(w http.ResponseWriter, r *http.Request, claims *types.CyberoClaims, admin bool) {
+
+	owner := claims.Username
+
+	if admin {
+		owner = r.URL.Query().Get("owner")
+	}
+
+	sendResponse(w, http.StatusOK, 0, map[string]interface{}{"Keys": auth.apiKeys.list(owner)})
+}
+
+func (auth *AuthManager) revokeKey(w http.ResponseWriter, r *http.Request, claims *types.CyberoClaims, admin bool) {
+
+	logger := GetLogManager().GetLogger()
+
+	if r.Method != http.MethodPost {
+		sendResponse(w, http.StatusMethodNotAllowed, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, method not allowed %q\n", r.Method)})
+		return
+	}
+
+	var request types.CyberoAPIKeyRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ID == "" {
+		sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": "Error, invalid API key request\n"})
+		return
+	}
+
+	// Do not disclose the keys of other users
+	if key, ok := auth.apiKeys.get(request.ID); !ok || (!admin && key.Owner != claims.Username) {
+		sendResponse(w, http.StatusNotFound, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, API key does not exist %q\n", request.ID)})
+		return
+	}
+
+	if err := auth.apiKeys.revoke(request.ID); err != nil {
+		logger.Printf("Auth: Error revoking API key %q: %v\n", request.ID, err)
+		sendResponse(w, http.StatusInternalServerError, -1, map[string]interface{}{"Error": "Error, unable to revoke API key\n"})
+		return
+	}
+
+	logger.Printf("Auth: API key %q revoked by %q\n", request.ID, claims.Username)
+	sendResponse(w, http.StatusOK, 0, map[string]interface{}{"Info": "API key revoked\n"})
+}
+
+// unlockAction clear the failed signins of a username or address
+func (auth *AuthManager) unlockAction(w http.ResponseWriter, r *http.Request) error {
+
+	logger := GetLogManager().GetLogger()
+
+	if r.Method != http.MethodPost {
+		sendResponse(w, http.StatusMethodNotAllowed, -1, map[string]interface{}{"Error": fmt.Sprintf("Error, method not allowed %q\n", r.Method)})
+		return nil
+	}
+
+	claims, err := auth.VerifyRequest(r)
+
+	if err != nil {
+		logger.Printf("Auth: Unauthorized request to unlock: %v\n", err)
+		sendResponse(w, http.StatusUnauthorized, -1, map[string]interface{}{"Error": "Error, authentication required\n"})
+		return nil
+	}
+
+	if !hasScopes(claims, []string{ScopeAuthAdmin}) {
+		logger.Printf("Auth: User %q not authorized to unlock\n", claims.Username)
+		sendResponse(w, http.StatusForbidden, -1, map[string]interface{}{"Error": "Error, not authorized\n"})
+		return nil
+	}
+
+	var request types.CyberoUnlockRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || (request.Username == "" && request.Address == "") {
+		sendResponse(w, http.StatusBadRequest, -1, map[string]interface{}{"Error": "Error, invalid unlock request\n"})
+		return nil
+	}
+
+	unlocked := []string{}
+
+	if request.Username != "" && auth.lockout.reset(userLockoutKey(request.Username)) {
+		unlocked = append(unlocked, userLockoutKey(request.Username))
+	}
+
+	if request.Address != "" && auth.lockout.reset(addressLockoutKey(request.Address)) {
+		unlocked = append(unlocked, addressLockoutKey(request.Address))
+	}
+
+	for _, key := range unlocked {
+		logger.Printf("Auth: event=unlock key=%q by=%q\n", key, claims.Username)
+	}
+
+	sendResponse(w, http.StatusOK, 0, map[string]interface{}{"Unlocked": unlocked})
 	return nil
 }
 
+// collectExpired periodically removes expired revocations and refresh tokens
+func (auth *AuthManager) collectExpired() {
+
+	logger := GetLogManager().GetLogger()
+
+	for range time.Tick(collectInterval) {
+
+		if err := auth.revoked.prune(); err != nil {
+			logger.Printf("Auth: Error persisting revoked tokens: %v\n", err)
+		}
+
+		auth.refreshTokens.collect()
+		auth.lockout.collect()
+	}
+}
+
+// certificateClaims returns the identity of a verified client certificate,
+// the common name is used as the username
+func certificateClaims(cert *x509.Certificate) (*types.CyberoClaims, error) {
+
+	if cert.Subject.CommonName == "" {
+		return nil, errors.New("Client certificate without common name")
+	}
+
+	claims := &types.CyberoClaims{
+		Username: cert.Subject.CommonName,
+		Provider: CertificateProvider,
+	}
+	claims.Subject = cert.Subject.String()
+	claims.ExpiresAt = cert.NotAfter.Unix()
+
+	grantClaims(claims, nil)
+	return claims, nil
+}
+
+// VerifyRequest verify the credentials presented by a request
+func (auth *AuthManager) VerifyRequest(r *http.Request) (*types.CyberoClaims, error) {
+
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+
+		entry, err := auth.apiKeys.verify(key)
+		if err != nil {
+			return nil, err
+		}
+
+		claims := &types.CyberoClaims{
+			Username: entry.Owner,
+			Scopes:   entry.Scopes,
+			Provider: APIKeyProvider,
+		}
+		claims.Id = entry.ID
+		claims.ExpiresAt = entry.Expires
+
+		return claims, nil
+	}
+
+	tokenString := bearerToken(r)
+
+	if tokenString == "" {
+
+		cfg := GetConfigManager().serverConfig()
+
+		// A verified client certificate identifies the client
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			return certificateClaims(r.TLS.VerifiedChains[0][0])
+		}
+
+		// So does the process connected to the unix socket
+		if cfg.Auth.PeerCred.Enabled {
+			return peerClaims(r.Context())
+		}
+
+		return nil, errors.New("No credentials provided")
+	}
+
+	var claims *types.CyberoClaims
+	var err error
+
+	// Tokens of the OpenID Connect issuer are validated against its keys
+	if auth.oidc != nil && tokenIssuer(tokenString) == auth.oidc.config.Issuer {
+		claims, err = auth.oidc.validate(tokenString)
+	} else {
+		claims, err = auth.parseToken(tokenString)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Id != "" && auth.revoked.isRevoked(claims.Id) {
+		return nil, errors.New("Token was revoked")
+	}
+
+	return claims, nil
+}
+
 // HandleRequest handle a request for the security auth
 func (auth *AuthManager) HandleRequest(w http.ResponseWriter, r *http.Request) error {
 
@@ -67,12 +688,45 @@ func GetAuthManager() *AuthManager {
 	authSync.Do(func() {
 
 		logger.Println("Auth: Initializing authentication Layer")
-		auth = &AuthManager{}
+		cfg := GetConfigManager().serverConfig()
+
+		revoked := newRevocationStore(cfg.Auth.RevocationFile)
+
+		auth = &AuthManager{
+			refreshTokens: newRefreshTokenStore(revoked),
+			revoked:       revoked,
+			apiKeys:       newAPIKeyStore(cfg.Auth.KeysFile),
+			lockout:       newLockoutTracker(),
+		}
+
+		// The server refuses to start without its keys
+		auth.keys, auth.keysErr = loadKeyring(cfg.Auth)
+		if auth.keysErr != nil {
+			logger.Printf("Auth: Error loading signing keys: %v\n", auth.keysErr)
+		}
+
+		if cfg.Auth.OIDC.Issuer != "" {
+			auth.oidc = newOIDCValidator(cfg.Auth.OIDC)
+		}
+
+		if err := auth.revoked.load(); err != nil {
+			logger.Printf("Auth: Error loading revoked tokens from %q: %v\n", cfg.Auth.RevocationFile, err)
+		}
+
+		if err := auth.apiKeys.load(); err != nil {
+			logger.Printf("Auth: Error loading API keys from %q: %v\n", cfg.Auth.KeysFile, err)
+		}
+
+		go auth.collectExpired()
 
 		// Initialize authentication callbacks maps
 		auth.authActions = map[string]types.CyberoHandler{
 			"signin":  auth.signinAction,
 			"refresh": auth.refreshAction,
+			"signout": auth.signoutAction,
+			"jwks":    auth.jwksAction,
+			"keys":    auth.keysAction,
+			"unlock":  auth.unlockAction,
 		}
 
 	})
@@ -0,0 +1,191 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerThreshold consecutive failures opening a module breaker
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown seconds calls are refused once the breaker opens
+	DefaultBreakerCooldown = 30
+)
+
+// circuitBreaker refuse calls to a module failing repeatedly, once the
+// cool down is over a single trial call decides if it closes again
+type circuitBreaker struct {
+	sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// breakerLimits returns the configured threshold and cool down
+func breakerLimits() (int, time.Duration) {
+
+	cfg := GetConfigManager().serverConfig()
+
+	threshold, cooldown := cfg.Modules.BreakerThreshold, cfg.Modules.BreakerCooldown
+
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+
+	return threshold, time.Duration(cooldown) * time.Second
+}
+
+// allow returns true if a call can go through, or how long to wait
+func (breaker *circuitBreaker) allow() (bool, time.Duration) {
+
+	threshold, _ := breakerLimits()
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	if breaker.failures < threshold {
+		return true, 0
+	}
+
+	if wait := time.Until(breaker.openUntil); wait > 0 {
+		return false, wait
+	}
+
+	// Half open, let a single call through
+	if breaker.trial {
+		return false, time.Second
+	}
+
+	breaker.trial = true
+	return true, 0
+}
+
+// success close the breaker
+func (breaker *circuitBreaker) success() {
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	breaker.failures, breaker.trial = 0, false
+}
+
+// failure count a failed call, the breaker opens once the threshold is
+// reached or a trial call fails
+func (breaker *circuitBreaker) failure() {
+
+	threshold, cooldown := breakerLimits()
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	breaker.failures++
+	breaker.trial = false
+
+	if breaker.failures >= threshold {
+		breaker.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+// state returns closed, open or half-open
+func (breaker *circuitBreaker) state() string {
+
+	threshold, _ := breakerLimits()
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	switch {
+	case breaker.failures < threshold:
+		return "closed"
+	case time.Now().Before(breaker.openUntil):
+		return "open"
+	}
+
+	return "half-open"
+}
+
+// trackingWriter remember if the response was started and its status, a
+// response already started can not be replaced by an error
+type trackingWriter struct {
+	http.ResponseWriter
+	written bool
+	status  int
+}
+
+func (w *trackingWriter) WriteHeader(status int) {
+	if !w.written {
+		w.status = status
+	}
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackingWriter) Write(data []byte) (int, error) {
+	if !w.written {
+		w.status = http.StatusOK
+	}
+	w.written = true
+	return w.ResponseWriter.Write(data)
+}
+
+// failed returns true if the module answered with a server error
+func (w *trackingWriter) failed() bool {
+	return w.status >= http.StatusInternalServerError
+}
+
+// Flush let streaming modules flush their responses
+func (w *trackingWriter) Flush() {
+	w.written = true
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack let modules take over the connection, the response is then
+// considered written
+func (w *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	w.written = true
+	return hijacker.Hijack()
+}
+
+// Push let modules use HTTP/2 server push
+func (w *trackingWriter) Push(target string, opts *http.PushOptions) error {
+
+	if pusher, ok := w.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+// Unwrap give access to the original writer
+func (w *trackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
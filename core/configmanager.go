@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"sync"
@@ -26,6 +27,7 @@ import (
 
 // ConfigManager represents a config manager struct
 type ConfigManager struct {
+	sync.Mutex
 	masterConfig *types.CyberoServerConfig
 	configFile   string
 }
@@ -71,15 +73,127 @@ func (config *ConfigManager) loadFormArgs() {
 	flag.BoolVar(&config.masterConfig.TLS, "tls", false, "Use TLS encryption")
 	flag.StringVar(&config.masterConfig.CertPEM, "pem", "", "TLS PEM file")
 	flag.StringVar(&config.masterConfig.CertKey, "key", "", "TLS key file")
+	flag.StringVar(&config.masterConfig.ClientCA, "clientca", "", "TLS client CA file")
+	flag.StringVar(&config.masterConfig.ClientAuth, "clientauth", "", "TLS client authentication mode (verify, require)")
 	flag.StringVar(&config.masterConfig.Modules.Path, "modules", "/var/lib/modules", "Modiles location")
 	flag.Parse()
 }
 
-// GetConfig access to the current config manager
+// GetConfig returns a copy of the current config without secrets, keys
+// or the modules configuration, safe to hand to modules
 func (config *ConfigManager) GetConfig() *types.CyberoServerConfig {
+
+	sanitized := &types.CyberoServerConfig{}
+
+	// A deep copy, changing it never changes the server config
+	config.Lock()
+	data, err := json.Marshal(config.masterConfig)
+	config.Unlock()
+
+	if err == nil {
+		err = json.Unmarshal(data, sanitized)
+	}
+
+	if err != nil {
+		GetLogManager().GetLogger().Printf("ConfigManager: Error copying the config: %v\n", err)
+	}
+
+	sanitized.CertKey = ""
+	sanitized.Auth.Secret = nil
+	sanitized.Auth.Config = nil
+	sanitized.Modules.Configuration = nil
+
+	for i := range sanitized.Auth.Keys {
+		sanitized.Auth.Keys[i].PrivateKey = ""
+	}
+
+	return sanitized
+}
+
+// serverConfig access to the current config, core only
+func (config *ConfigManager) serverConfig() *types.CyberoServerConfig {
 	return config.masterConfig
 }
 
+// moduleConfig returns the configuration of a module
+func (config *ConfigManager) moduleConfig(name string) (types.CyberoModuleConfig, bool) {
+
+	config.Lock()
+	defer config.Unlock()
+
+	moduleConfig, ok := config.masterConfig.Modules.Configuration[name]
+	return moduleConfig, ok
+}
+
+// moduleConfigs returns the configuration of every module, the map is
+// replaced, never changed, by setModuleConfig
+func (config *ConfigManager) moduleConfigs() map[string]types.CyberoModuleConfig {
+
+	config.Lock()
+	defer config.Unlock()
+
+	return config.masterConfig.Modules.Configuration
+}
+
+// setModuleConfig replace the configuration of a module, the modules
+// configuration is copied so readers never see a map being written
+func (config *ConfigManager) setModuleConfig(name string, moduleConfig types.CyberoModuleConfig) {
+
+	config.Lock()
+	defer config.Unlock()
+
+	modules := make(map[string]types.CyberoModuleConfig, len(config.masterConfig.Modules.Configuration)+1)
+	for key, value := range config.masterConfig.Modules.Configuration {
+		modules[key] = value
+	}
+
+	modules[name] = moduleConfig
+	config.masterConfig.Modules.Configuration = modules
+}
+
+// saveModuleConfig write the configuration of a module back to the config
+// file, the rest of the file is kept as is
+func (config *ConfigManager) saveModuleConfig(name string) error {
+
+	config.Lock()
+	defer config.Unlock()
+
+	data, err := ioutil.ReadFile(config.configFile)
+	if err != nil {
+		return err
+	}
+
+	file := map[string]interface{}{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	modules, _ := file["modules"].(map[string]interface{})
+	if modules == nil {
+		modules = map[string]interface{}{}
+		file["modules"] = modules
+	}
+
+	configs, _ := modules["configs"].(map[string]interface{})
+	if configs == nil {
+		configs = map[string]interface{}{}
+		modules["configs"] = configs
+	}
+
+	configs[name] = config.masterConfig.Modules.Configuration[name]
+
+	if data, err = json.MarshalIndent(file, "", "  "); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(config.configFile)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(config.configFile, append(data, '\n'), info.Mode().Perm())
+}
+
 // GetConfigManager access to the current config manager
 func GetConfigManager() *ConfigManager {
 
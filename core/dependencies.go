@@ -0,0 +1,193 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"cybero/types"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// pendingModule a opened module waiting to be initialized
+type pendingModule struct {
+	file   moduleFile
+	module types.CyberoModule
+}
+
+// moduleDependencies returns the dependencies declared by a module
+func moduleDependencies(module interface{}) map[string]string {
+
+	if dependent, ok := module.(types.CyberoDependentModule); ok {
+		return dependent.Dependencies()
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys of a dependencies map in order
+func sortedKeys(dependencies map[string]string) []string {
+
+	keys := make([]string, 0, len(dependencies))
+	for key := range dependencies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// sortModules order the modules so each one comes after the modules it
+// depends on, modules in a dependency cycle are returned as failed
+func sortModules(pending []*pendingModule) ([]*pendingModule, map[string]error) {
+
+	byName := pendingByName(pending)
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	ordered := make([]*pendingModule, 0, len(pending))
+	failed := make(map[string]error)
+	state := make(map[*pendingModule]int, len(pending))
+	path := []*pendingModule{}
+
+	var visit func(module *pendingModule)
+	visit = func(module *pendingModule) {
+
+		switch state[module] {
+		case visited:
+			return
+		case visiting:
+			// Every module on the path since the first visit is in the cycle
+			start := 0
+			for path[start] != module {
+				start++
+			}
+
+			cycle := []string{}
+			for _, member := range append(path[start:], module) {
+				cycle = append(cycle, member.module.Name())
+			}
+
+			err := fmt.Errorf("dependency cycle %s", strings.Join(cycle, " -> "))
+
+			for _, member := range path[start:] {
+				failed[member.module.Name()] = err
+			}
+			return
+		}
+
+		state[module] = visiting
+		path = append(path, module)
+
+		dependencies := moduleDependencies(module.module)
+
+		// Dependencies already loaded or missing are checked later
+		for _, dependency := range sortedKeys(dependencies) {
+			for _, version := range byName[dependency] {
+				visit(version)
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[module] = visited
+		ordered = append(ordered, module)
+	}
+
+	for _, name := range names {
+		for _, module := range byName[name] {
+			visit(module)
+		}
+	}
+
+	return ordered, failed
+}
+
+// pendingByName group the pending modules by name, a module may have more
+// than one version loaded side by side
+func pendingByName(pending []*pendingModule) map[string][]*pendingModule {
+
+	byName := make(map[string][]*pendingModule, len(pending))
+
+	for _, module := range pending {
+		name := module.module.Name()
+		byName[name] = append(byName[name], module)
+	}
+
+	return byName
+}
+
+// checkDependencies check the dependencies of a module are loaded with a
+// version in the required range, dependencies being loaded are checked
+// once initialized
+func (mod *ModulesManager) checkDependencies(module types.CyberoModule, pending map[string][]*pendingModule) error {
+
+	dependencies := moduleDependencies(module)
+
+	for _, name := range sortedKeys(dependencies) {
+
+		constraint, err := parseConstraint(dependencies[name])
+		if err != nil {
+			return fmt.Errorf("dependency %q: %v", name, err)
+		}
+
+		versions := mod.loadedVersions(name)
+
+		if len(versions) == 0 {
+
+			if _, ok := pending[name]; ok {
+				return fmt.Errorf("dependency %q failed to load", name)
+			}
+
+			return fmt.Errorf("missing dependency %q", name)
+		}
+
+		allowed := false
+		for _, version := range versions {
+			allowed = allowed || constraint.allows(version)
+		}
+
+		if !allowed {
+			return fmt.Errorf("dependency %q version %s is not in range %q", name, strings.Join(versions, ", "), dependencies[name])
+		}
+	}
+
+	return nil
+}
+
+// loadedVersions returns the versions of a module loaded
+func (mod *ModulesManager) loadedVersions(name string) []string {
+
+	mod.RLock()
+	defer mod.RUnlock()
+
+	versions := []string{}
+
+	for _, handle := range mod.loaded {
+		if module, ok := handle.module.(types.CyberoModule); ok && module.Name() == name {
+			versions = append(versions, module.Version())
+		}
+	}
+
+	return versions
+}
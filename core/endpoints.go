@@ -0,0 +1,118 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"cybero/types"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ConflictFail refuse to start when two modules use the same endpoint
+	ConflictFail = "fail"
+	// ConflictFirstWins keep the module loaded first on an endpoint
+	ConflictFirstWins = "first-wins"
+	// ConflictRename serve a conflicting module on its configured endpoint
+	ConflictRename = "rename"
+)
+
+// checkConflictPolicy returns an error for an unknown conflict policy, no
+// policy is first-wins
+func checkConflictPolicy(policy string) error {
+
+	switch policy {
+	case "", ConflictFirstWins, ConflictFail, ConflictRename:
+		return nil
+	}
+
+	return fmt.Errorf("invalid endpoint conflict policy %q", policy)
+}
+
+// endpointConflictError a module endpoint already used by another module
+// or reserved for the builtin actions
+type endpointConflictError struct {
+	endpoint string
+	module   string
+	owner    string
+}
+
+func (err *endpointConflictError) Error() string {
+
+	if err.owner == BuiltinModule {
+		return fmt.Sprintf("endpoint %q of module %q is reserved", err.endpoint, err.module)
+	}
+
+	return fmt.Sprintf("endpoint %q of module %q is already used by module %q", err.endpoint, err.module, err.owner)
+}
+
+// endpointOwner returns the module serving an endpoint, must be called
+// locked
+func (mod *ModulesManager) endpointOwner(endpoint string) (string, bool) {
+
+	if endpoint == BuiltinModule || isBuiltinAction(endpoint) {
+		return BuiltinModule, true
+	}
+
+	// /api/vN selects a module version
+	if _, ok := parseAPIVersion(endpoint); ok && strings.HasPrefix(endpoint, "v") {
+		return BuiltinModule, true
+	}
+
+	if handles, ok := mod.apiModules[endpoint]; ok {
+		return handles[0].name, true
+	}
+
+	return "", false
+}
+
+// resolveEndpoint returns the endpoint a module is served on, under the
+// rename policy a module with a conflicting endpoint is served on the
+// endpoint set in its configuration. Must be called locked
+func (mod *ModulesManager) resolveEndpoint(name string, handler types.CyberoHandlerModule) (string, error) {
+
+	cfg := GetConfigManager().serverConfig()
+
+	endpoint := handler.Endpoint()
+
+	if endpoint == "" || strings.Contains(endpoint, "/") {
+		return "", fmt.Errorf("invalid endpoint %q of module %q", endpoint, name)
+	}
+
+	// Other versions of a module share its endpoint
+	owner, taken := mod.endpointOwner(endpoint)
+	if !taken || owner == name {
+		return endpoint, nil
+	}
+
+	conflict := &endpointConflictError{endpoint: endpoint, module: name, owner: owner}
+
+	moduleConfig, _ := GetConfigManager().moduleConfig(name)
+	alias := moduleConfig.Endpoint
+
+	if cfg.Modules.ConflictPolicy != ConflictRename || alias == "" {
+		return "", conflict
+	}
+
+	if strings.Contains(alias, "/") {
+		return "", fmt.Errorf("invalid endpoint %q of module %q", alias, name)
+	}
+
+	if owner, taken := mod.endpointOwner(alias); taken && owner != name {
+		return "", &endpointConflictError{endpoint: alias, module: name, owner: owner}
+	}
+
+	return alias, nil
+}
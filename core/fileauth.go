@@ -0,0 +1,298 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"cybero/types"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// FileAuthProvider the name of the builtin file authentication provider
+const FileAuthProvider = "file"
+
+// dummyHash is checked for unknown users so they take as long as known ones
+var dummyHash = []byte("$2a$10$DuiieZs7PAxF.o2T7PJEYeEL0Nag8.SF.HQugMgxDHFzUDmKYkojy")
+
+// fileUser a user entry of the users file
+type fileUser struct {
+	hash  string
+	roles []string
+}
+
+// FileAuthModule authenticate users against a htpasswd like file, each
+// line holds "username:hash[:role,role]" where hash is a bcrypt or argon2
+// hash, the file is reloaded when it changes
+type FileAuthModule struct {
+	sync.Mutex
+	logger      *log.Logger
+	file        string
+	modTime     time.Time
+	size        int64
+	users       map[string]fileUser
+	initialized bool
+}
+
+// Initialize load the users file given by the "file" config entry
+func (module *FileAuthModule) Initialize(logger *log.Logger, config map[string]interface{}) error {
+
+	file, ok := config["file"].(string)
+
+	if !ok || file == "" {
+		return errors.New("no users file configured")
+	}
+
+	module.Lock()
+	defer module.Unlock()
+
+	module.logger = logger
+	module.file = file
+
+	if err := module.reload(); err != nil {
+		return err
+	}
+
+	module.initialized = true
+	return nil
+}
+
+// IsInitialized returns true if the users file was loaded
+func (module *FileAuthModule) IsInitialized() bool {
+	return module.initialized
+}
+
+// Name returns the name of the module
+func (module *FileAuthModule) Name() string {
+	return FileAuthProvider
+}
+
+// Version returns the version of the module
+func (module *FileAuthModule) Version() string {
+	return "1.0.0"
+}
+
+// Info returns information about the module
+func (module *FileAuthModule) Info() string {
+	return "Builtin provider, authenticate users against a bcrypt/argon2 hashed users file."
+}
+
+// Authenticate check the credentials against the users file
+func (module *FileAuthModule) Authenticate(credentials *types.CyberoCredentials) bool {
+
+	user, ok := module.lookup(credentials.Username)
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(credentials.Password))
+		return false
+	}
+
+	match, err := checkPassword(user.hash, credentials.Password)
+
+	if err != nil {
+		module.logger.Printf("FileAuth: Error checking password of user %q: %v\n", credentials.Username, err)
+		return false
+	}
+
+	return match
+}
+
+// Roles returns the roles given to the user in the users file
+func (module *FileAuthModule) Roles(username string) []string {
+
+	user, _ := module.lookup(username)
+	return user.roles
+}
+
+// lookup returns the entry of a user, reloading the file if it changed
+func (module *FileAuthModule) lookup(username string) (fileUser, bool) {
+
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.initialized {
+		return fileUser{}, false
+	}
+
+	if err := module.reload(); err != nil {
+		module.logger.Printf("FileAuth: Error reloading users file %q: %v\n", module.file, err)
+	}
+
+	user, ok := module.users[username]
+	return user, ok
+}
+
+// reload read the users file if it changed since the last load, must be
+// called locked
+func (module *FileAuthModule) reload() error {
+
+	info, err := os.Stat(module.file)
+	if err != nil {
+		return err
+	}
+
+	if module.users != nil && info.ModTime().Equal(module.modTime) && info.Size() == module.size {
+		return nil
+	}
+
+	fileDscr, err := os.Open(module.file)
+	if err != nil {
+		return err
+	}
+	defer fileDscr.Close()
+
+	users := make(map[string]fileUser)
+	scanner := bufio.NewScanner(fileDscr)
+	lineNumber := 0
+
+	for scanner.Scan() {
+
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			return fmt.Errorf("invalid entry on line %d", lineNumber)
+		}
+
+		user := fileUser{hash: fields[1]}
+
+		if len(fields) == 3 {
+			for _, role := range strings.Split(fields[2], ",") {
+				if role = strings.TrimSpace(role); role != "" {
+					user.roles = append(user.roles, role)
+				}
+			}
+		}
+
+		users[fields[0]] = user
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	module.users = users
+	module.modTime = info.ModTime()
+	module.size = info.Size()
+
+	if module.logger != nil {
+		module.logger.Printf("FileAuth: Loaded %d users from %q\n", len(users), module.file)
+	}
+
+	return nil
+}
+
+// checkPassword compare a password with a bcrypt or argon2 hash
+func checkPassword(hash string, password string) (bool, error) {
+
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+
+		return err == nil, err
+	}
+
+	if strings.HasPrefix(hash, "$argon2") {
+		return checkArgon2(hash, password)
+	}
+
+	return false, errors.New("unsupported hash format")
+}
+
+// Limits of the argon2 parameters accepted from a hash, memory in KiB
+const (
+	argon2MaxMemory      = 1024 * 1024
+	argon2MaxIterations  = 32
+	argon2MaxParallelism = 16
+)
+
+// checkArgon2 compare a password with a argon2 hash in the PHC string format
+// $argon2id$v=19$m=65536,t=3,p=4$salt$hash
+func checkArgon2(hash string, password string) (bool, error) {
+
+	parts := strings.Split(hash, "$")
+
+	if len(parts) != 6 {
+		return false, errors.New("invalid argon2 hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, err
+	}
+
+	if version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, err
+	}
+
+	// Zero costs panic and huge ones exhaust the server
+	if memory == 0 || memory > argon2MaxMemory || iterations == 0 || iterations > argon2MaxIterations ||
+		parallelism == 0 || parallelism > argon2MaxParallelism {
+		return false, fmt.Errorf("unsupported argon2 parameters m=%d,t=%d,p=%d", memory, iterations, parallelism)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, err
+	}
+
+	if len(key) == 0 {
+		return false, errors.New("invalid argon2 hash")
+	}
+
+	var derived []byte
+
+	switch parts[1] {
+	case "argon2id":
+		derived = argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	case "argon2i":
+		derived = argon2.Key([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	default:
+		return false, fmt.Errorf("unsupported argon2 variant %q", parts[1])
+	}
+
+	return subtle.ConstantTimeCompare(derived, key) == 1, nil
+}
@@ -0,0 +1,294 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strings"
+)
+
+// SignatureExt the extension of detached plugin signatures, module.so.sig
+const SignatureExt = ".sig"
+
+// moduleManifest the sha256 digests of the plugin files, relative to the
+// modules path
+type moduleManifest map[string]string
+
+// loadManifest read a manifest in the sha256sum format, "digest  file"
+// lines, a empty file name means no manifest
+func loadManifest(file string) (moduleManifest, error) {
+
+	manifest := make(moduleManifest)
+
+	if file == "" {
+		return manifest, nil
+	}
+
+	if err := checkWritable(file, filepath.Dir(file)); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("manifest %q line %d: expected digest and file", file, line)
+		}
+
+		// sha256sum marks binary mode files with a "*"
+		name := filepath.Clean(strings.TrimPrefix(fields[1], "*"))
+		manifest[name] = strings.ToLower(fields[0])
+	}
+
+	return manifest, nil
+}
+
+// loadModuleKeys read the ed25519 public keys trusted to sign plugins
+func loadModuleKeys(files []string) ([]ed25519.PublicKey, error) {
+
+	keys := []ed25519.PublicKey{}
+
+	for _, file := range files {
+
+		if err := checkWritable(file, filepath.Dir(file)); err != nil {
+			return nil, err
+		}
+
+		public, err := loadPublicKey(file)
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := public.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("module key %q is not a ed25519 key", file)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// checkWritable refuse a file or any directory up to root writable by
+// its group or anyone, they could replace the file. A symbolic link is
+// checked as the file it points to, with the directories of the target up
+// to root, or its own directory if outside root
+func checkWritable(file string, root string) error {
+
+	if err := checkPathWritable(file, root); err != nil {
+		return err
+	}
+
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return err
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	if resolved == filepath.Clean(file) && resolvedRoot == filepath.Clean(root) {
+		return nil
+	}
+
+	if relative, err := filepath.Rel(resolvedRoot, resolved); err != nil || strings.HasPrefix(relative, "..") {
+		resolvedRoot = filepath.Dir(resolved)
+	}
+
+	return checkPathWritable(resolved, resolvedRoot)
+}
+
+// checkPathWritable refuse a path or any directory up to root writable by
+// its group or anyone
+func checkPathWritable(file string, root string) error {
+
+	root = filepath.Clean(root)
+
+	for path := filepath.Clean(file); ; path = filepath.Dir(path) {
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().Perm()&0022 != 0 {
+			return fmt.Errorf("%q is group or world writable", path)
+		}
+
+		if path == root || path == filepath.Dir(path) {
+			return nil
+		}
+	}
+}
+
+// verifySignature check a detached signature, raw or base64 encoded,
+// against the trusted keys
+func verifySignature(data []byte, signature []byte, keys []ed25519.PublicKey) bool {
+
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return false
+		}
+		signature = decoded
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key, data, signature) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifyModule check a plugin file before it is opened, returns the bytes
+// verified. A file listed in the manifest must match its digest and a
+// signed file must have a valid signature, in strict mode a file must be
+// listed or signed
+func verifyModule(file moduleFile) ([]byte, error) {
+
+	cfg := GetConfigManager().serverConfig()
+	logger := GetLogManager().GetLogger()
+
+	if err := checkWritable(file.path, cfg.Modules.Path); err != nil {
+		return nil, err
+	}
+
+	manifest, err := loadManifest(cfg.Modules.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := loadModuleKeys(cfg.Modules.PublicKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(file.path)
+	if err != nil {
+		return nil, err
+	}
+
+	verified := false
+
+	name, err := filepath.Rel(cfg.Modules.Path, file.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if expected, ok := manifest[name]; ok {
+
+		digest := sha256.Sum256(data)
+
+		if hex.EncodeToString(digest[:]) != expected {
+			return nil, fmt.Errorf("plugin %q does not match the manifest digest", file.path)
+		}
+
+		verified = true
+	}
+
+	signature, err := ioutil.ReadFile(file.path + SignatureExt)
+
+	if err == nil {
+
+		if !verifySignature(data, signature, keys) {
+			return nil, fmt.Errorf("plugin %q has an invalid signature", file.path)
+		}
+
+		verified = true
+
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if !verified {
+
+		if cfg.Modules.Strict {
+			return nil, fmt.Errorf("plugin %q is not signed or in the manifest", file.path)
+		}
+
+		logger.Printf("ModulesManager: Plugin %q is not signed or in the manifest\n", file.path)
+	}
+
+	return data, nil
+}
+
+// openPlugin open the verified bytes of a plugin. They are copied to a
+// directory only the server user can access, so the file loaded is the one
+// verified even if the original is replaced meanwhile. Go never unloads a
+// plugin, a plugin opened again is found by its digest
+func (mod *ModulesManager) openPlugin(file moduleFile, data []byte) (*plugin.Plugin, error) {
+
+	digest := sha256.Sum256(data)
+	key := hex.EncodeToString(digest[:])
+
+	mod.RLock()
+	opened, ok := mod.plugins[key]
+	mod.RUnlock()
+
+	if ok {
+		return opened, nil
+	}
+
+	dir, err := ioutil.TempDir("", "cybero-plugin-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, filepath.Base(file.path))
+
+	if err := ioutil.WriteFile(path, data, 0500); err != nil {
+		return nil, err
+	}
+
+	opened, err = plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mod.Lock()
+	mod.plugins[key] = opened
+	mod.Unlock()
+
+	return opened, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// readJSONFile decode a JSON file into value, a missing file leaves value
+// as is
+func readJSONFile(file string, value interface{}) error {
+
+	fileDscr, err := os.Open(file)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+	defer fileDscr.Close()
+
+	return json.NewDecoder(fileDscr).Decode(value)
+}
+
+// writeJSONFile encode value to a JSON file, the file is replaced at once
+func writeJSONFile(file string, value interface{}, perm os.FileMode) error {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(file, data, perm)
+}
+
+// writeFileAtomic replace a file with data, the data is written to a
+// temporary file first so a crash never leaves the file truncated
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+
+	tmpFile := file + ".tmp"
+
+	if err := ioutil.WriteFile(tmpFile, data, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, file)
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// jsonWebKey a public key in the JSON Web Key format (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// jsonWebKeySet a set of JSON Web Keys
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// paddedBytes returns the big endian bytes of n padded to size
+func paddedBytes(n *big.Int, size int) []byte {
+
+	buf := n.Bytes()
+
+	if len(buf) >= size {
+		return buf
+	}
+
+	padded := make([]byte, size)
+	copy(padded[size-len(buf):], buf)
+	return padded
+}
+
+// newJSONWebKey returns the JSON Web Key of a public key
+func newJSONWebKey(kid string, alg string, public interface{}) (jsonWebKey, error) {
+
+	encoding := base64.RawURLEncoding
+	key := jsonWebKey{Kid: kid, Alg: alg, Use: "sig"}
+
+	switch public := public.(type) {
+
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = encoding.EncodeToString(public.N.Bytes())
+		key.E = encoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = public.Curve.Params().Name
+		key.X = encoding.EncodeToString(paddedBytes(public.X, size))
+		key.Y = encoding.EncodeToString(paddedBytes(public.Y, size))
+
+	case ed25519.PublicKey:
+		key.Kty = "OKP"
+		key.Crv = "Ed25519"
+		key.X = encoding.EncodeToString(public)
+
+	default:
+		return key, fmt.Errorf("unsupported public key type %T", public)
+	}
+
+	return key, nil
+}
+
+// publicKey returns the public key described by the JSON Web Key
+func (key jsonWebKey) publicKey() (interface{}, error) {
+
+	encoding := base64.RawURLEncoding
+
+	switch key.Kty {
+
+	case "RSA":
+		n, err := encoding.DecodeString(key.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := encoding.DecodeString(key.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+
+		x, err := encoding.DecodeString(key.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := encoding.DecodeString(key.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case "OKP":
+		if key.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+
+		x, err := encoding.DecodeString(key.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key size %d", len(x))
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", key.Kty)
+}
@@ -0,0 +1,347 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"cybero/types"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// signingMethodEdDSA implements the EdDSA signing method (RFC 8037) for
+// Ed25519 keys, which jwt-go does not provide
+type signingMethodEdDSA struct{}
+
+// SigningMethodEdDSA the EdDSA signing method
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (method *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (method *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errors.New("EdDSA verification failed")
+	}
+
+	return nil
+}
+
+func (method *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// signingKey a key used to sign or verify tokens
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// keyring holds the keys known by the server, tokens are signed with the
+// signing key and verified with the key matching their kid header
+type keyring struct {
+	keys    map[string]*signingKey
+	order   []string
+	signing *signingKey
+}
+
+// readPEM returns the first PEM block of a file
+func readPEM(file string) (*pem.Block, error) {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %q", file)
+	}
+
+	return block, nil
+}
+
+// loadPrivateKey load a PKCS8, PKCS1 or EC private key from a PEM file
+func loadPrivateKey(file string) (crypto.Signer, error) {
+
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unable to parse private key %q", file)
+}
+
+// loadPublicKey load a PKIX or PKCS1 public key or a certificate from a PEM file
+func loadPublicKey(file string) (crypto.PublicKey, error) {
+
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+
+	return nil, fmt.Errorf("unable to parse public key %q", file)
+}
+
+// keyMatchesMethod returns true if the public key can be used with the method
+func keyMatchesMethod(method jwt.SigningMethod, public crypto.PublicKey) bool {
+
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodRSA)
+		return ok
+	case *ecdsa.PublicKey:
+		// ES256, ES384 and ES512 each use their own curve
+		ecdsaMethod, ok := method.(*jwt.SigningMethodECDSA)
+		return ok && ecdsaMethod.CurveBits == key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return method == SigningMethodEdDSA
+	}
+
+	return false
+}
+
+// keyID returns a key id derived from the public key
+func keyID(public crypto.PublicKey) (string, error) {
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+// loadKey load a configured key pair
+func loadKey(config types.CyberoAuthKeyConfig) (*signingKey, error) {
+
+	method := jwt.GetSigningMethod(config.Algorithm)
+
+	if method == nil {
+		return nil, fmt.Errorf("unsupported algorithm %q", config.Algorithm)
+	}
+
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		return nil, fmt.Errorf("algorithm %q is not asymmetric, use the auth secret", config.Algorithm)
+	}
+
+	key := &signingKey{id: config.ID, method: method}
+
+	if config.PrivateKey != "" {
+
+		private, err := loadPrivateKey(config.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		key.private = private
+		key.public = private.Public()
+
+	} else if config.PublicKey != "" {
+
+		public, err := loadPublicKey(config.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		key.public = public
+
+	} else {
+		return nil, errors.New("no private or public key file given")
+	}
+
+	if !keyMatchesMethod(method, key.public) {
+		return nil, fmt.Errorf("key type %T can not be used with %q", key.public, config.Algorithm)
+	}
+
+	if key.id == "" {
+		id, err := keyID(key.public)
+		if err != nil {
+			return nil, err
+		}
+		key.id = id
+	}
+
+	return key, nil
+}
+
+// loadKeyring load the configured keys, the auth secret is used as a
+// HS256 key for tokens without a kid
+func loadKeyring(config types.CyberoAuthConfig) (*keyring, error) {
+
+	ring := &keyring{keys: make(map[string]*signingKey)}
+
+	if len(config.Secret) > 0 {
+		ring.keys[""] = &signingKey{
+			method:  jwt.SigningMethodHS256,
+			private: config.Secret,
+			public:  config.Secret,
+		}
+		ring.signing = ring.keys[""]
+	}
+
+	var preferred, fallback *signingKey
+
+	for _, keyConfig := range config.Keys {
+
+		key, err := loadKey(keyConfig)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", keyConfig.ID, err)
+		}
+
+		if _, ok := ring.keys[key.id]; ok {
+			return nil, fmt.Errorf("duplicated key id %q", key.id)
+		}
+
+		ring.keys[key.id] = key
+		ring.order = append(ring.order, key.id)
+
+		if key.private == nil {
+			continue
+		}
+
+		if keyConfig.Signing && preferred == nil {
+			preferred = key
+		}
+
+		if fallback == nil {
+			fallback = key
+		}
+	}
+
+	// The first key marked for signing wins, otherwise the first private key
+	if preferred != nil {
+		ring.signing = preferred
+	} else if fallback != nil {
+		ring.signing = fallback
+	}
+
+	if ring.signing == nil {
+		return nil, errors.New("no signing key configured")
+	}
+
+	return ring, nil
+}
+
+// sign returns the claims signed with the signing key
+func (ring *keyring) sign(claims jwt.Claims) (string, error) {
+
+	token := jwt.NewWithClaims(ring.signing.method, claims)
+
+	if ring.signing.id != "" {
+		token.Header["kid"] = ring.signing.id
+	}
+
+	return token.SignedString(ring.signing.private)
+}
+
+// keyFunc returns the key to verify a token, the token algorithm must
+// match the algorithm of the key
+func (ring *keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ring.keys[kid]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	return key.public, nil
+}
+
+// jwks returns the public keys of the keyring, the secret is never published
+func (ring *keyring) jwks() jsonWebKeySet {
+
+	set := jsonWebKeySet{Keys: []jsonWebKey{}}
+
+	for _, id := range ring.order {
+
+		key := ring.keys[id]
+
+		if jwk, err := newJSONWebKey(key.id, key.method.Alg(), key.public); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set
+}
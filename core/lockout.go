@@ -0,0 +1,265 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	defaultMaxFailures     = 5
+	defaultBackoff         = time.Second
+	defaultLockoutDuration = 15 * time.Minute
+)
+
+// failureCounter the failed signins of a username or address and the
+// signins in progress
+type failureCounter struct {
+	failures    int
+	pending     int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// lockoutTracker count failed signins per username and remote address,
+// each failure doubles the time before the next attempt is allowed and
+// too many failures lock the username or address
+type lockoutTracker struct {
+	sync.Mutex
+	counters map[string]*failureCounter
+}
+
+func newLockoutTracker() *lockoutTracker {
+	return &lockoutTracker{counters: make(map[string]*failureCounter)}
+}
+
+func userLockoutKey(username string) string {
+	return "user:" + username
+}
+
+func addressLockoutKey(address string) string {
+	return "address:" + address
+}
+
+// remoteHost returns the address of the client without the port
+func remoteHost(r *http.Request) string {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// lockoutLimits the limits applied to a key, backoff starts after the
+// free failures and the key is locked after max failures
+type lockoutLimits struct {
+	maxFailures  int
+	freeFailures int
+	backoff      time.Duration
+	duration     time.Duration
+}
+
+// lockoutConfig returns the configured limits of a key
+func lockoutConfig(key string) lockoutLimits {
+
+	cfg := GetConfigManager().serverConfig()
+
+	limits := lockoutLimits{
+		maxFailures: defaultMaxFailures,
+		backoff:     defaultBackoff,
+		duration:    defaultLockoutDuration,
+	}
+
+	if cfg.Auth.Lockout.MaxFailures > 0 {
+		limits.maxFailures = cfg.Auth.Lockout.MaxFailures
+	}
+
+	if cfg.Auth.Lockout.Backoff > 0 {
+		limits.backoff = time.Duration(cfg.Auth.Lockout.Backoff) * time.Second
+	}
+
+	if cfg.Auth.Lockout.Duration > 0 {
+		limits.duration = time.Duration(cfg.Auth.Lockout.Duration) * time.Minute
+	}
+
+	// An address is shared by many users, it only backs off once it failed
+	// as much as a single user can
+	if strings.HasPrefix(key, addressLockoutKey("")) {
+
+		limits.freeFailures = limits.maxFailures
+		limits.maxFailures *= 4
+
+		if cfg.Auth.Lockout.MaxAddressFailures > 0 {
+			limits.maxFailures = cfg.Auth.Lockout.MaxAddressFailures
+		}
+	}
+
+	return limits
+}
+
+// check returns how long the caller must wait before trying again, zero
+// if the attempt is allowed. An allowed attempt is counted in progress until
+// released, so parallel attempts can not all pass before one fails
+func (tracker *lockoutTracker) check(keys ...string) time.Duration {
+
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(0)
+
+	for _, key := range keys {
+
+		counter, ok := tracker.counters[key]
+		if !ok {
+			continue
+		}
+
+		if remaining := counter.lockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+
+		limits := lockoutConfig(key)
+
+		// Past the free failures attempts are made one at a time
+		if counter.pending > 0 && counter.failures+counter.pending > limits.freeFailures && limits.backoff > wait {
+			wait = limits.backoff
+		}
+
+		exponent := counter.failures - limits.freeFailures - 1
+
+		if exponent < 0 {
+			continue
+		}
+
+		// Exponential backoff from the last failure, capped to the lockout
+		delay := limits.duration
+		if exponent < 32 && limits.backoff<<uint(exponent) < limits.duration {
+			delay = limits.backoff << uint(exponent)
+		}
+
+		if remaining := counter.last.Add(delay).Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	if wait > 0 {
+		return wait
+	}
+
+	for _, key := range keys {
+
+		counter, ok := tracker.counters[key]
+		if !ok {
+			counter = &failureCounter{}
+			tracker.counters[key] = counter
+		}
+
+		counter.pending++
+	}
+
+	return 0
+}
+
+// release end an attempt allowed by check
+func (tracker *lockoutTracker) release(keys ...string) {
+
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	for _, key := range keys {
+		if counter, ok := tracker.counters[key]; ok && counter.pending > 0 {
+			counter.pending--
+		}
+	}
+}
+
+// fail record a failed attempt, returning the keys that became locked
+func (tracker *lockoutTracker) fail(keys ...string) []string {
+
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	now := time.Now()
+	var locked []string
+
+	for _, key := range keys {
+
+		counter, ok := tracker.counters[key]
+		if !ok {
+			counter = &failureCounter{}
+			tracker.counters[key] = counter
+		}
+
+		counter.failures++
+		counter.last = now
+
+		limits := lockoutConfig(key)
+
+		if counter.failures >= limits.maxFailures && now.After(counter.lockedUntil) {
+			counter.lockedUntil = now.Add(limits.duration)
+			locked = append(locked, key)
+		}
+	}
+
+	return locked
+}
+
+// failures returns the number of failures and lock expiry of a key
+func (tracker *lockoutTracker) failures(key string) (int, time.Time) {
+
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	if counter, ok := tracker.counters[key]; ok {
+		return counter.failures, counter.lockedUntil
+	}
+
+	return 0, time.Time{}
+}
+
+// reset forget the failures of a key, returns false if there were none
+func (tracker *lockoutTracker) reset(key string) bool {
+
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	_, ok := tracker.counters[key]
+	delete(tracker.counters, key)
+
+	return ok
+}
+
+// collect forget the keys without failures for a lockout duration
+func (tracker *lockoutTracker) collect() {
+
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	now := time.Now()
+
+	for key, counter := range tracker.counters {
+
+		if counter.pending == 0 && now.After(counter.lockedUntil) && now.Sub(counter.last) > lockoutConfig(key).duration {
+			delete(tracker.counters, key)
+		}
+	}
+}
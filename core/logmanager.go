@@ -41,7 +41,7 @@ func GetLogManager() *LogManager {
 	coreSync.Do(func() {
 
 		// Get the current configuration
-		config := GetConfigManager().GetConfig()
+		config := GetConfigManager().serverConfig()
 
 		// Instanciate the log manager
 		logManager = &LogManager{}
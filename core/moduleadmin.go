@@ -0,0 +1,221 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"cybero/types"
+	"fmt"
+)
+
+// loadedHandles returns the loaded versions of a module by its name, must
+// be called locked
+func (mod *ModulesManager) loadedHandles(name string) []*moduleHandle {
+
+	handles := []*moduleHandle{}
+
+	for _, handle := range mod.loaded {
+		if handle.name == name {
+			handles = append(handles, handle)
+		}
+	}
+
+	return handles
+}
+
+// setEnabled update the enabled flag of a module configuration, builtin
+// modules have no configuration
+func setEnabled(name string, enabled bool) {
+
+	manager := GetConfigManager()
+
+	config, ok := manager.moduleConfig(name)
+	if !ok {
+		return
+	}
+
+	config.Enabled = enabled
+
+	manager.setModuleConfig(name, config)
+}
+
+// IsDisabled returns true if a loaded module was disabled at runtime
+func (mod *ModulesManager) IsDisabled(name string) bool {
+
+	mod.RLock()
+	defer mod.RUnlock()
+
+	handles := mod.loadedHandles(name)
+
+	for _, handle := range handles {
+		if !handle.disabled {
+			return false
+		}
+	}
+
+	return len(handles) > 0
+}
+
+// setDisabled disable or enable every loaded version of a module, returns
+// false if none is loaded
+func (mod *ModulesManager) setDisabled(name string, disabled bool) bool {
+
+	mod.Lock()
+	handles := mod.loadedHandles(name)
+	for _, handle := range handles {
+		handle.disabled = disabled
+	}
+	mod.Unlock()
+
+	for _, handle := range handles {
+		mod.services.setDisabled(handle.services, disabled)
+	}
+
+	return len(handles) > 0
+}
+
+// DisableModule stop serving a module while keeping it loaded, its
+// endpoint answers 503 and its services are unavailable
+func (mod *ModulesManager) DisableModule(name string) error {
+
+	logger := GetLogManager().GetLogger()
+
+	if !mod.setDisabled(name, true) {
+		return fmt.Errorf("module %q is not loaded", name)
+	}
+
+	setEnabled(name, false)
+
+	logger.Printf("ModulesManager: Module %q disabled\n", name)
+	return nil
+}
+
+// EnableModule serve a disabled module again, a module not loaded is
+// loaded from the modules path
+func (mod *ModulesManager) EnableModule(name string) error {
+
+	logger := GetLogManager().GetLogger()
+
+	// Loading must not race a reload loading the same plugin
+	mod.loading.Lock()
+	defer mod.loading.Unlock()
+
+	if mod.setDisabled(name, false) {
+		setEnabled(name, true)
+
+		logger.Printf("ModulesManager: Module %q enabled\n", name)
+		return nil
+	}
+
+	if _, configured := GetConfigManager().moduleConfig(name); !configured {
+		return fmt.Errorf("module %q is not configured", name)
+	}
+
+	setEnabled(name, true)
+
+	files := []moduleFile{}
+	for _, file := range mod.scanModules() {
+		if file.name == name {
+			files = append(files, file)
+		}
+	}
+
+	if len(files) == 0 {
+		setEnabled(name, false)
+		return fmt.Errorf("module %q not found", name)
+	}
+
+	if loaded, _ := mod.loadModules(files); len(loaded) == 0 {
+		setEnabled(name, false)
+		return fmt.Errorf("module %q could not be loaded, see the log", name)
+	}
+
+	logger.Printf("ModulesManager: Module %q enabled\n", name)
+	return nil
+}
+
+// ReinitializeModule shutdown, initialize and start again the loaded
+// versions of a module with a new configuration, or the current one if nil.
+// The module is disabled until its running requests finish and it is
+// started, a module failing to initialize stays disabled
+func (mod *ModulesManager) ReinitializeModule(name string, config map[string]interface{}) error {
+
+	logger := GetLogManager().GetLogger()
+	manager := GetConfigManager()
+
+	mod.loading.Lock()
+	defer mod.loading.Unlock()
+
+	mod.RLock()
+	handles := mod.loadedHandles(name)
+	disabled := len(handles) > 0
+	for _, handle := range handles {
+		disabled = disabled && handle.disabled
+	}
+	mod.RUnlock()
+
+	if len(handles) == 0 {
+		return fmt.Errorf("module %q is not loaded", name)
+	}
+
+	moduleConfig, _ := manager.moduleConfig(name)
+
+	if config != nil {
+		moduleConfig.Config = config
+		manager.setModuleConfig(name, moduleConfig)
+	}
+
+	mod.setDisabled(name, true)
+
+	for _, handle := range handles {
+
+		handle.requests.Wait()
+
+		module, ok := handle.module.(types.CyberoModule)
+		if !ok {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), ModuleShutdownTimeout)
+		err := shutdownModule(ctx, module)
+		cancel()
+
+		if err != nil {
+			logger.Printf("ModulesManager: Error shutting down module %q: %v\n", handle.key, err)
+		}
+
+		modContext, err := newModuleContext(name, moduleConfig.Config, handle.services)
+		if err == nil {
+			err = initializeContext(module, modContext)
+		}
+
+		if err == nil {
+			err = startModule(module)
+		}
+
+		if err != nil {
+			setEnabled(name, false)
+			logger.Printf("ModulesManager: Module %q disabled\n", name)
+			return fmt.Errorf("module %q failed to initialize and was disabled: %v", name, err)
+		}
+	}
+
+	if !disabled {
+		mod.setDisabled(name, false)
+	}
+
+	logger.Printf("ModulesManager: Module %q initialized again\n", name)
+	return nil
+}
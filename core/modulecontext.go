@@ -0,0 +1,138 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"cybero/types"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultModuleDataPath where the modules data directories are created
+const DefaultModuleDataPath = "/var/lib/cybero"
+
+// moduleContext what a module is given to initialize, the module only sees
+// a copy of its own configuration
+type moduleContext struct {
+	name     string
+	config   map[string]interface{}
+	logger   *log.Logger
+	dataDir  string
+	services types.CyberoServiceRegistry
+}
+
+// copyConfig returns a deep copy of a module configuration
+func copyConfig(config map[string]interface{}) map[string]interface{} {
+
+	copied := map[string]interface{}{}
+
+	if data, err := json.Marshal(config); err == nil {
+		json.Unmarshal(data, &copied)
+	}
+
+	return copied
+}
+
+// checkModuleName returns an error if a module name can not be used as
+// its data directory name
+func checkModuleName(name string) error {
+
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid module name %q", name)
+	}
+
+	return nil
+}
+
+// newModuleContext create the context of a module, the configuration is
+// the current one unless given
+func newModuleContext(name string, config map[string]interface{}, services *moduleServices) (*moduleContext, error) {
+
+	if err := checkModuleName(name); err != nil {
+		return nil, err
+	}
+
+	cfg := GetConfigManager().serverConfig()
+	logger := GetLogManager().GetLogger()
+
+	if config == nil {
+		moduleConfig, _ := GetConfigManager().moduleConfig(name)
+		config = moduleConfig.Config
+	}
+
+	dataPath := cfg.Modules.DataPath
+	if dataPath == "" {
+		dataPath = DefaultModuleDataPath
+	}
+
+	context := &moduleContext{
+		name:    name,
+		config:  copyConfig(config),
+		logger:  log.New(logger.Writer(), name+": ", logger.Flags()|log.Lmsgprefix),
+		dataDir: filepath.Join(dataPath, name),
+	}
+
+	// A nil registry in a interface is not nil
+	if services != nil {
+		context.services = services
+	}
+
+	return context, nil
+}
+
+// initializeContext initialize a module with its context
+func initializeContext(moduleImpl types.CyberoModule, context *moduleContext) error {
+
+	if module, ok := moduleImpl.(types.CyberoContextModule); ok {
+		return module.InitializeContext(context)
+	}
+
+	return moduleImpl.Initialize(context.logger, context.config)
+}
+
+// Name returns the module name
+func (context *moduleContext) Name() string {
+	return context.name
+}
+
+// Config returns a copy of the module configuration
+func (context *moduleContext) Config() map[string]interface{} {
+	return copyConfig(context.config)
+}
+
+// Logger returns a logger prefixing the lines with the module name
+func (context *moduleContext) Logger() *log.Logger {
+	return context.logger
+}
+
+// DataDir returns the module data directory, created on first use and
+// only accessible by the server user
+func (context *moduleContext) DataDir() string {
+
+	if err := os.MkdirAll(context.dataDir, 0700); err != nil {
+		context.logger.Printf("Error creating data directory %q: %v\n", context.dataDir, err)
+	}
+
+	return context.dataDir
+}
+
+// Services returns the service registry
+func (context *moduleContext) Services() types.CyberoServiceRegistry {
+	return context.services
+}
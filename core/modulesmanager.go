@@ -1,145 +1,634 @@
 package core
 
 import (
+	"context"
 	"cybero/types"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
 	"plugin"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // ModulesManager holds and maintain the current modules stack
 type ModulesManager struct {
-	apiModules  map[string]interface{}
-	authModules map[string]interface{}
+	sync.RWMutex
+	loading     sync.Mutex
+	apiModules  map[string][]*moduleHandle
+	authModules map[string]*moduleHandle
+	moduleFiles map[string]moduleFile
+	loaded      []*moduleHandle
+	services    *serviceRegistry
+	plugins     map[string]*plugin.Plugin
+}
+
+// moduleHandle a loaded module, running requests are counted so a replaced
+// module is only shutdown once they finish
+type moduleHandle struct {
+	name     string
+	key      string
+	version  string
+	module   interface{}
+	services *moduleServices
+	disabled bool
+	breaker  circuitBreaker
+	requests sync.WaitGroup
+}
+
+// moduleFile a module plugin file, versioned plugins are named
+// name@version.so so a new version can be loaded next to the old one.
+// Statically linked modules have no path
+type moduleFile struct {
+	name    string
+	version string
+	slot    string
+	path    string
+	modTime time.Time
+	module  types.CyberoModule
 }
 
 var (
 	modulesSync    sync.Once
 	modulesManager *ModulesManager
+
+	// ModuleShutdownTimeout how long a replaced module has to shutdown
+	ModuleShutdownTimeout = 30 * time.Second
 )
 
-//LoadModules load all modules
-func (mod *ModulesManager) LoadModules() {
+// parseModuleFile extract the module name and version from a plugin
+// filename /xx/xx/module@version.so -> module, version
+func parseModuleFile(fPath string, info os.FileInfo) (moduleFile, bool) {
+
+	if info.IsDir() || filepath.Ext(info.Name()) != ".so" {
+		return moduleFile{}, false
+	}
+
+	name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+	version := ""
+
+	if index := strings.LastIndex(name, "@"); index > 0 {
+		name, version = name[:index], name[index+1:]
+	}
+
+	return moduleFile{name: name, version: version, path: fPath, modTime: info.ModTime()}, true
+}
+
+// key identifies the module, or the major version of a module loaded side
+// by side with other versions
+func (file moduleFile) key() string {
+
+	if file.slot == "" {
+		return file.name
+	}
+
+	return file.name + "@" + file.slot
+}
+
+// scanModules returns the newest plugin file of each module, or of each
+// major version configured to be loaded side by side. A statically linked
+// module is only replaced by a plugin with a newer version
+func (mod *ModulesManager) scanModules() map[string]moduleFile {
 
-	cfg := GetConfigManager().GetConfig()
+	cfg := GetConfigManager().serverConfig()
 	logger := GetLogManager().GetLogger()
 
+	files := staticModuleFiles()
+
 	filepath.Walk(cfg.Modules.Path, func(fPath string, info os.FileInfo, err error) error {
 
 		if err != nil {
-			logger.Printf("ModuleManager: Error accessing path %q: %v\n", fPath, err)
+			logger.Printf("ModulesManager: Error accessing path %q: %v\n", fPath, err)
+			return nil
+		}
+
+		file, ok := parseModuleFile(fPath, info)
+		if !ok {
 			return nil
 		}
 
-		if info.IsDir() {
+		if file.slot, ok = versionSlot(file.name, file.version); !ok {
 			return nil
 		}
 
-		// Extract module name from filename /xx/xx/module.xxx -> module
-		name := strings.ReplaceAll(filepath.Base(info.Name()), filepath.Ext(info.Name()), "")
+		if current, ok := files[file.key()]; !ok || compareVersions(file.version, current.version) > 0 {
+			files[file.key()] = file
+		}
+
+		return nil
+	})
+
+	// Modules configured to run as processes are never plugins
+	for name, file := range processModuleFiles() {
+		files[name] = file
+	}
+
+	return files
+}
+
+// openModule open a plugin file and lookup the module it holds, a nil
+// module is returned if the module is not enabled
+func (mod *ModulesManager) openModule(file moduleFile) (types.CyberoModule, error) {
+
+	logger := GetLogManager().GetLogger()
+
+	config, ok := GetConfigManager().moduleConfig(file.name)
+
+	if !ok || !config.Enabled {
+		logger.Printf("ModulesManager: Plugin %q not loaded. is it enabled?\n", file.name)
+		return nil, nil
+	}
+
+	if file.module != nil && config.Kind == ProcessModuleKind {
+		logger.Printf("ModulesManager: Module %q runs as a process\n", file.name)
+		return file.module, nil
+	}
+
+	if file.module != nil {
+		logger.Printf("ModulesManager: Module %q is statically linked\n", file.name)
+		return file.module, nil
+	}
+
+	data, err := verifyModule(file)
+	if err != nil {
+		return nil, err
+	}
+
+	module, err := mod.openPlugin(file, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var moduleImpl types.CyberoModule
+
+	// Check if module is a Cybero handler or a Authentication provider
+	if symModule, err := module.Lookup("CyberoRestHandler"); err == nil {
+		logger.Printf("ModulesManager: Plugin %q is a Cybero handler\n", file.path)
+		moduleImpl, _ = symModule.(types.CyberoHandlerModule)
+	} else if symModule, err := module.Lookup("CyberoAuthProvider"); err == nil {
+		logger.Printf("ModulesManager: Plugin %q is a Auth provider\n", file.path)
+		moduleImpl, _ = symModule.(types.CyberoAuthModule)
+	}
+
+	if moduleImpl == nil {
+		logger.Printf("ModulesManager: Plugin %q is not a Cybero module\n", file.path)
+		return nil, nil
+	}
+
+	return moduleImpl, nil
+}
+
+// initializeModule initialize and start a opened module, services
+// published by a module failing to initialize are withdrawn
+func (mod *ModulesManager) initializeModule(file moduleFile, moduleImpl types.CyberoModule, services *moduleServices) error {
+
+	logger := GetLogManager().GetLogger()
+
+	setServices(moduleImpl, services)
+
+	// Initialize plugin with its own context
+	modContext, err := newModuleContext(file.name, nil, services)
+	if err != nil {
+		return err
+	}
+
+	if err := initializeContext(moduleImpl, modContext); err != nil {
+		mod.services.withdraw(services)
+		return err
+	}
+
+	if err := startModule(moduleImpl); err != nil {
+		mod.services.withdraw(services)
+		return err
+	}
+
+	logger.Printf("ModulesManager: Module loaded and initialized: %q, version: %q\n", moduleImpl.Name(), moduleImpl.Version())
+	return nil
+}
 
-		// No module on the cache, try to load it from modules folder
-		pluginFile := path.Join(cfg.Modules.Path, name+".so")
-		module, err := plugin.Open(pluginFile)
+// loadModules open the plugin files and initialize their modules with the
+// dependencies first, returns the names of the modules loaded and the
+// endpoint conflicts found
+func (mod *ModulesManager) loadModules(files []moduleFile) ([]string, []error) {
+
+	logger := GetLogManager().GetLogger()
+
+	pending := []*pendingModule{}
+
+	for _, file := range files {
+
+		moduleImpl, err := mod.openModule(file)
 
 		if err != nil {
-			logger.Printf("API: Error processing module %q: %v\n", name, err)
-			return nil
+			logger.Printf("ModulesManager: Error loading module %q: %v\n", file.name, err)
+			continue
+		}
+
+		if moduleImpl != nil {
+			pending = append(pending, &pendingModule{file: file, module: moduleImpl})
 		}
+	}
 
-		// Check if module is a Cybero handler
-		logger.Printf("ModuleManager: Checking if %q is a Cybero handler\n", pluginFile)
-		if symModule, err := module.Lookup("CyberoRestHandler"); err == nil {
+	ordered, failed := sortModules(pending)
+	byName := pendingByName(pending)
 
-			if moduleImpl, ok := symModule.(types.CyberoHandlerModule); ok {
+	loaded := []string{}
+	conflicts := []error{}
 
-				if config, ok := cfg.Modules.Configuration[name]; ok {
+	for _, module := range ordered {
 
-					if config.Enabled {
+		err, ok := failed[module.module.Name()]
 
-						// Initialize plugin with arguments
-						if err = moduleImpl.Initialize(logger, config.Config); err != nil {
-							logger.Printf("ModulesManager: Error initializing module %q: %v\n", name, err)
-							return nil
-						}
+		if !ok {
+			err = mod.checkDependencies(module.module, byName)
+		}
 
-						logger.Printf("ModulesManager: Module loaded and initialized: %q, version: %q\n", moduleImpl.Name(), moduleImpl.Version())
-						mod.apiModules[moduleImpl.Endpoint()] = moduleImpl
+		services := mod.services.forModule(module.file.name)
 
-						return nil
-					}
+		if err == nil {
+			err = mod.initializeModule(module.file, module.module, services)
+		}
 
-				}
+		// Process modules only know their endpoint once initialized
+		if err == nil {
+			if err = mod.registerModule(module.file, module.module, services); err != nil {
+				mod.discardModule(module.module, services)
 			}
+		}
 
-			logger.Printf("ModuleManager: Plugin %q not loaded. is it enabled?\n", name)
-			return nil
+		if err != nil {
+			logger.Printf("ModulesManager: Error loading module %q: %v\n", module.file.key(), err)
+
+			if _, ok := err.(*endpointConflictError); ok {
+				conflicts = append(conflicts, err)
+			}
+			continue
 		}
 
-		// Check if module is a Authentication provider
-		logger.Printf("ModuleManager: Checking if %q is a Auth provider\n", pluginFile)
-		if symModule, err := module.Lookup("CyberoAuthProvider"); err == nil {
+		loaded = append(loaded, module.file.key())
+	}
+
+	return loaded, conflicts
+}
+
+// discardModule shutdown a initialized module that could not be registered
+func (mod *ModulesManager) discardModule(moduleImpl interface{}, services *moduleServices) {
 
-			if moduleImpl, ok := symModule.(types.CyberoAuthModule); ok {
+	mod.services.withdraw(services)
 
-				if config, ok := cfg.Modules.Configuration[name]; ok {
+	ctx, cancel := context.WithTimeout(context.Background(), ModuleShutdownTimeout)
+	defer cancel()
 
-					if config.Enabled {
+	shutdownModule(ctx, moduleImpl)
+}
+
+// startModule start a initialized module, a module failing to start is
+// shutdown
+func startModule(moduleImpl interface{}) error {
+
+	module, ok := moduleImpl.(types.CyberoStartModule)
+	if !ok {
+		return nil
+	}
+
+	if err := module.Start(); err != nil {
+
+		ctx, cancel := context.WithTimeout(context.Background(), ModuleShutdownTimeout)
+		defer cancel()
+
+		shutdownModule(ctx, moduleImpl)
+		return err
+	}
+
+	return nil
+}
+
+// shutdownModule shutdown a module if it has anything to release
+func shutdownModule(ctx context.Context, moduleImpl interface{}) error {
+
+	if module, ok := moduleImpl.(types.CyberoShutdownModule); ok {
+		return module.Shutdown(ctx)
+	}
 
-						// Initialize plugin with arguments
-						if err = moduleImpl.Initialize(logger, config.Config); err != nil {
-							logger.Printf("ModulesManager: Error initializing module %q: %v\n", name, err)
-							return nil
-						}
+	return nil
+}
+
+// registerModule make a initialized module available, replacing any previous
+// version, requests already running keep using the previous version
+func (mod *ModulesManager) registerModule(file moduleFile, moduleImpl interface{}, services *moduleServices) error {
+
+	mod.Lock()
+
+	handle := &moduleHandle{name: file.name, key: file.key(), module: moduleImpl, services: services}
+	var previous []*moduleHandle
+
+	if module, ok := moduleImpl.(types.CyberoModule); ok {
+		handle.version = module.Version()
+	}
+
+	if handler, ok := moduleImpl.(types.CyberoHandlerModule); ok {
 
-						logger.Printf("ModulesManager: Module loaded and initialized: %q, version: %q\n", moduleImpl.Name(), moduleImpl.Version())
-						mod.authModules[name] = moduleImpl
-						return nil
-					}
+		endpoint, err := mod.resolveEndpoint(file.name, handler)
+		if err != nil {
+			mod.Unlock()
+			return err
+		}
+
+		// The previous version may have been serving on a different endpoint
+		for endpoint, handles := range mod.apiModules {
+
+			kept := []*moduleHandle{}
 
+			for _, current := range handles {
+				if current.key == handle.key {
+					previous = append(previous, current)
+				} else {
+					kept = append(kept, current)
 				}
 			}
 
-			logger.Printf("ModuleManager: Plugin %q not loaded. is it enabled?\n", name)
-			return nil
+			mod.apiModules[endpoint] = kept
+
+			if len(kept) == 0 {
+				delete(mod.apiModules, endpoint)
+			}
 		}
 
-		logger.Printf("ModuleManager: Error processing file %q: %v\n", name, err)
-		return nil
-	})
+		mod.addAPIHandle(endpoint, handle)
+
+	} else if _, ok := moduleImpl.(types.CyberoAuthModule); ok {
+
+		if current, ok := mod.authModules[file.name]; ok {
+			previous = append(previous, current)
+		}
+
+		mod.authModules[file.name] = handle
+	}
+
+	for _, retired := range previous {
+		mod.removeLoaded(retired)
+	}
+
+	mod.loaded = append(mod.loaded, handle)
+	mod.moduleFiles[file.key()] = file
+	mod.Unlock()
+
+	mod.services.commit(services)
+
+	for _, retired := range previous {
+		mod.services.withdraw(retired.services)
+		go mod.retireModule(retired)
+	}
+
+	return nil
+}
+
+// removeLoaded remove a module from the load order, must be called locked
+func (mod *ModulesManager) removeLoaded(handle *moduleHandle) {
+
+	for i, loaded := range mod.loaded {
+		if loaded == handle {
+			mod.loaded = append(mod.loaded[:i], mod.loaded[i+1:]...)
+			return
+		}
+	}
+}
+
+// retireModule shutdown a replaced module once its requests finish
+func (mod *ModulesManager) retireModule(handle *moduleHandle) {
+
+	logger := GetLogManager().GetLogger()
+
+	handle.requests.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ModuleShutdownTimeout)
+	defer cancel()
+
+	if err := shutdownModule(ctx, handle.module); err != nil {
+		logger.Printf("ModulesManager: Error shutting down replaced module %q: %v\n", handle.name, err)
+	}
+}
+
+// Shutdown shutdown the modules in the reverse order they were loaded
+func (mod *ModulesManager) Shutdown(ctx context.Context) {
+
+	logger := GetLogManager().GetLogger()
+
+	mod.Lock()
+	loaded := mod.loaded
+	mod.loaded = nil
+	mod.Unlock()
+
+	for i := len(loaded) - 1; i >= 0; i-- {
+
+		logger.Printf("ModulesManager: Shutting down module %q\n", loaded[i].name)
+		mod.services.withdraw(loaded[i].services)
+
+		if err := shutdownModule(ctx, loaded[i].module); err != nil {
+			logger.Printf("ModulesManager: Error shutting down module %q: %v\n", loaded[i].name, err)
+		}
+	}
+}
+
+// Health returns the health of each loaded module, nil if healthy
+func (mod *ModulesManager) Health() map[string]error {
+
+	mod.RLock()
+	loaded := []*moduleHandle{}
+	for _, handle := range mod.loaded {
+		// Disabled modules were stopped on purpose
+		if !handle.disabled {
+			loaded = append(loaded, handle)
+		}
+	}
+	mod.RUnlock()
+
+	health := make(map[string]error, len(loaded))
+
+	for _, handle := range loaded {
+
+		health[handle.key] = nil
+
+		if module, ok := handle.module.(types.CyberoHealthModule); ok {
+			health[handle.key] = module.Health()
+		}
+	}
+
+	return health
+}
+
+// LoadModules load all modules, modules are initialized after the
+// modules they depend on. Under the fail conflict policy an error is
+// returned, and the modules shutdown, if two modules use the same endpoint
+func (mod *ModulesManager) LoadModules() error {
+
+	cfg := GetConfigManager().serverConfig()
+
+	if err := checkConflictPolicy(cfg.Modules.ConflictPolicy); err != nil {
+		return err
+	}
+
+	mod.loading.Lock()
+	defer mod.loading.Unlock()
+
+	// Builtin providers are only initialized when used
+	mod.loadBuiltinAuthModules()
+
+	files := []moduleFile{}
+	for _, file := range mod.scanModules() {
+		files = append(files, file)
+	}
+
+	if _, conflicts := mod.loadModules(files); len(conflicts) > 0 && cfg.Modules.ConflictPolicy == ConflictFail {
+
+		ctx, cancel := context.WithTimeout(context.Background(), ModuleShutdownTimeout)
+		defer cancel()
+
+		mod.Shutdown(ctx)
+		return conflicts[0]
+	}
+
+	return nil
+}
+
+// ReloadModules load modules added to the modules path or with a new
+// version, returns the names of the modules loaded. Go refuses to load two
+// plugins with the same plugin path, each version must be a distinct build,
+// building from the source files as the Makefile does ensures that
+func (mod *ModulesManager) ReloadModules() []string {
+
+	logger := GetLogManager().GetLogger()
+
+	// A SIGHUP and a reload request must not load the same plugins twice
+	mod.loading.Lock()
+	defer mod.loading.Unlock()
+	files := []moduleFile{}
+
+	for key, file := range mod.scanModules() {
+
+		mod.RLock()
+		current, ok := mod.moduleFiles[key]
+		mod.RUnlock()
+
+		if ok && current.path == file.path {
+
+			// Go plugins are cached by path, a changed file can not be loaded again
+			if !file.modTime.Equal(current.modTime) {
+				logger.Printf("ModulesManager: Plugin %q changed but can not be reloaded, use a versioned filename\n", file.path)
+			}
+
+			continue
+		}
+
+		files = append(files, file)
+	}
+
+	reloaded, _ := mod.loadModules(files)
+
+	for _, name := range reloaded {
+		logger.Printf("ModulesManager: Module %q reloaded\n", name)
+	}
+
+	sort.Strings(reloaded)
+	return reloaded
+}
+
+// loadBuiltinAuthModules initialize the builtin authentication providers
+// present in the providers chain
+func (mod *ModulesManager) loadBuiltinAuthModules() {
+
+	cfg := GetConfigManager().serverConfig()
+	logger := GetLogManager().GetLogger()
+
+	for _, name := range authProviders() {
+
+		if name != FileAuthProvider {
+			continue
+		}
+
+		moduleImpl := &FileAuthModule{}
+
+		if err := moduleImpl.Initialize(logger, cfg.Auth.Config); err != nil {
+			logger.Printf("ModulesManager: Error initializing builtin module %q: %v\n", name, err)
+			continue
+		}
+
+		logger.Printf("ModulesManager: Builtin module loaded and initialized: %q, version: %q\n", moduleImpl.Name(), moduleImpl.Version())
+		mod.registerModule(moduleFile{name: name}, moduleImpl, nil)
+	}
 }
 
 // GetAuthModules get an registered authentication modules
 func (mod *ModulesManager) GetAuthModules() map[string]interface{} {
-	return mod.authModules
+
+	mod.RLock()
+	defer mod.RUnlock()
+
+	modules := make(map[string]interface{}, len(mod.authModules))
+	for name, handle := range mod.authModules {
+		modules[name] = handle.module
+	}
+
+	return modules
 }
 
 // GetAPIModules get an registered rest api modules
 func (mod *ModulesManager) GetAPIModules() map[string]interface{} {
-	return mod.apiModules
+
+	mod.RLock()
+	defer mod.RUnlock()
+
+	modules := make(map[string]interface{}, len(mod.apiModules))
+	for endpoint, handles := range mod.apiModules {
+		modules[endpoint] = mod.defaultHandle(handles).module
+	}
+
+	return modules
 }
 
 // GetAuthModule get an registered authentication module
 func (mod *ModulesManager) GetAuthModule(name string) (types.CyberoAuthModule, bool) {
 
-	if module, ok := mod.authModules[name]; ok {
-		return module.(types.CyberoAuthModule), ok
+	mod.RLock()
+	defer mod.RUnlock()
+
+	if handle, ok := mod.authModules[name]; ok && !handle.disabled {
+		return handle.module.(types.CyberoAuthModule), ok
 	}
 	return nil, false
 }
 
-// GetAPIModule get an registered rest api module
+// GetAPIModule get an registered rest api module, the default version
 func (mod *ModulesManager) GetAPIModule(name string) (types.CyberoHandlerModule, bool) {
-	if module, ok := mod.apiModules[name]; ok {
-		return module.(types.CyberoHandlerModule), ok
+
+	mod.RLock()
+	defer mod.RUnlock()
+
+	if handles, ok := mod.apiModules[name]; ok {
+		return mod.defaultHandle(handles).module.(types.CyberoHandlerModule), ok
 	}
 	return nil, false
 }
 
+// GetAPIModuleName get the configuration name of the module serving an endpoint
+func (mod *ModulesManager) GetAPIModuleName(endpoint string) (string, bool) {
+
+	mod.RLock()
+	defer mod.RUnlock()
+
+	if handles, ok := mod.apiModules[endpoint]; ok {
+		return handles[0].name, ok
+	}
+	return "", false
+}
+
+// release signal a request using the module finished
+func (handle *moduleHandle) release() {
+	handle.requests.Done()
+}
+
 // GetModuleManager returns the current module manager
 func GetModuleManager() *ModulesManager {
 
@@ -154,8 +643,11 @@ func GetModuleManager() *ModulesManager {
 		logger.Printf("ModuleManager: Initializing modules\n")
 
 		// Initialize modules cache
-		modulesManager.apiModules = make(map[string]interface{})
-		modulesManager.authModules = make(map[string]interface{})
+		modulesManager.apiModules = make(map[string][]*moduleHandle)
+		modulesManager.authModules = make(map[string]*moduleHandle)
+		modulesManager.services = newServiceRegistry()
+		modulesManager.moduleFiles = make(map[string]moduleFile)
+		modulesManager.plugins = make(map[string]*plugin.Plugin)
 	})
 
 	if configManager == nil {
@@ -0,0 +1,372 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"cybero/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// OIDCProvider the provider recorded in the claims of OpenID Connect tokens
+	OIDCProvider = "oidc"
+
+	defaultOIDCRefreshInterval = time.Hour
+	oidcMinRefreshInterval     = time.Minute
+	oidcRequestTimeout         = 10 * time.Second
+)
+
+// oidcKey a key published by the issuer
+type oidcKey struct {
+	alg    string
+	public interface{}
+}
+
+// oidcValidator validate tokens issued by an OpenID Connect provider
+// against the keys it publishes
+type oidcValidator struct {
+	sync.RWMutex
+	config     types.CyberoOIDCConfig
+	keys       map[string]oidcKey
+	fetched    time.Time
+	attempted  time.Time
+	lastErr    error
+	refreshing sync.Mutex
+	client     *http.Client
+}
+
+func newOIDCValidator(config types.CyberoOIDCConfig) *oidcValidator {
+	return &oidcValidator{
+		config: config,
+		keys:   make(map[string]oidcKey),
+		client: &http.Client{Timeout: oidcRequestTimeout},
+	}
+}
+
+// getJSON fetch a json document
+func (oidc *oidcValidator) getJSON(url string, v interface{}) error {
+
+	response, err := oidc.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %q fetching %q", response.Status, url)
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// jwksURL returns the url of the issuer keys, from the configuration or
+// from the discovery document
+func (oidc *oidcValidator) jwksURL() (string, error) {
+
+	if oidc.config.JWKSURL != "" {
+		return oidc.config.JWKSURL, nil
+	}
+
+	discovery := oidc.config.Discovery
+	if discovery == "" {
+		discovery = strings.TrimSuffix(oidc.config.Issuer, "/") + "/.well-known/openid-configuration"
+	}
+
+	var document struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+
+	if err := oidc.getJSON(discovery, &document); err != nil {
+		return "", err
+	}
+
+	if document.Issuer != oidc.config.Issuer {
+		return "", fmt.Errorf("discovery document issuer %q does not match %q", document.Issuer, oidc.config.Issuer)
+	}
+
+	if document.JWKSURI == "" {
+		return "", errors.New("discovery document without jwks_uri")
+	}
+
+	return document.JWKSURI, nil
+}
+
+// refresh reload the issuer keys
+func (oidc *oidcValidator) refresh() error {
+
+	var set jsonWebKeySet
+
+	if oidc.config.JWKSFile != "" {
+
+		data, err := ioutil.ReadFile(oidc.config.JWKSFile)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(data, &set); err != nil {
+			return err
+		}
+
+	} else {
+
+		url, err := oidc.jwksURL()
+		if err != nil {
+			return err
+		}
+
+		if err := oidc.getJSON(url, &set); err != nil {
+			return err
+		}
+	}
+
+	keys := make(map[string]oidcKey)
+
+	for _, jwk := range set.Keys {
+
+		// Keys meant for encryption can not verify tokens
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		public, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[jwk.Kid] = oidcKey{alg: jwk.Alg, public: public}
+	}
+
+	oidc.Lock()
+	defer oidc.Unlock()
+
+	oidc.keys = keys
+	oidc.fetched = time.Now()
+
+	return nil
+}
+
+// refreshOnce reload the issuer keys unless another request did while
+// waiting, concurrent requests share a single reload
+func (oidc *oidcValidator) refreshOnce(seen time.Time) error {
+
+	oidc.refreshing.Lock()
+	defer oidc.refreshing.Unlock()
+
+	oidc.RLock()
+	attempted, lastErr := oidc.attempted, oidc.lastErr
+	oidc.RUnlock()
+
+	if attempted.After(seen) {
+		return lastErr
+	}
+
+	err := oidc.refresh()
+
+	oidc.Lock()
+	oidc.attempted, oidc.lastErr = time.Now(), err
+	oidc.Unlock()
+
+	return err
+}
+
+// refreshInterval returns how often the issuer keys are reloaded
+func (oidc *oidcValidator) refreshInterval() time.Duration {
+
+	if oidc.config.RefreshInterval > 0 {
+		return time.Duration(oidc.config.RefreshInterval) * time.Minute
+	}
+
+	return defaultOIDCRefreshInterval
+}
+
+// lookupKey returns the key with a kid, keys are reloaded when stale or
+// when the kid is unknown, which happens when the issuer rotates its keys
+func (oidc *oidcValidator) lookupKey(kid string) (oidcKey, error) {
+
+	oidc.RLock()
+	key, ok := oidc.keys[kid]
+	age := time.Since(oidc.fetched)
+	attempted := oidc.attempted
+	oidc.RUnlock()
+
+	if ok && age < oidc.refreshInterval() {
+		return key, nil
+	}
+
+	// Reloads, failed ones too, only happen once in a while
+	if time.Since(attempted) < oidcMinRefreshInterval {
+
+		if ok {
+			return key, nil
+		}
+
+		return key, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if err := oidc.refreshOnce(attempted); err != nil {
+
+		logger := GetLogManager().GetLogger()
+		logger.Printf("Auth: Error loading keys of issuer %q: %v\n", oidc.config.Issuer, err)
+
+		// Stale keys are still better than no keys
+		if ok {
+			return key, nil
+		}
+
+		return key, err
+	}
+
+	oidc.RLock()
+	defer oidc.RUnlock()
+
+	if key, ok = oidc.keys[kid]; !ok {
+		return key, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	return key, nil
+}
+
+// keyFunc returns the key to verify a token, the token algorithm must
+// match the key
+func (oidc *oidcValidator) keyFunc(token *jwt.Token) (interface{}, error) {
+
+	kid, _ := token.Header["kid"].(string)
+
+	key, err := oidc.lookupKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if (key.alg != "" && key.alg != token.Method.Alg()) || !keyMatchesMethod(token.Method, key.public) {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	return key.public, nil
+}
+
+// claimValue returns the value of a claim, nested claims are given as a
+// dotted path, e.g. "realm_access.roles"
+func claimValue(claims jwt.MapClaims, path string) (interface{}, bool) {
+
+	var value interface{} = map[string]interface{}(claims)
+
+	for _, name := range strings.Split(path, ".") {
+
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// claimStrings returns a claim holding a string or a list of strings
+func claimStrings(claims jwt.MapClaims, path string) []string {
+
+	value, ok := claimValue(claims, path)
+	if !ok {
+		return nil
+	}
+
+	switch value := value.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		var list []string
+		for _, item := range value {
+			if str, ok := item.(string); ok {
+				list = append(list, str)
+			}
+		}
+		return list
+	}
+
+	return nil
+}
+
+// validate verify a token of the issuer and map it to a Cybero identity
+func (oidc *oidcValidator) validate(tokenString string) (*types.CyberoClaims, error) {
+
+	tokenClaims := jwt.MapClaims{}
+
+	if _, err := jwt.ParseWithClaims(tokenString, tokenClaims, oidc.keyFunc); err != nil {
+		return nil, err
+	}
+
+	// jwt-go accepts tokens without expiry, issuer tokens must expire
+	exp, ok := tokenClaims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token without \"exp\" claim")
+	}
+
+	if issuer, _ := tokenClaims["iss"].(string); issuer != oidc.config.Issuer {
+		return nil, fmt.Errorf("unexpected token issuer %q", issuer)
+	}
+
+	if oidc.config.Audience != "" {
+
+		found := false
+		for _, audience := range claimStrings(tokenClaims, "aud") {
+			if audience == oidc.config.Audience {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("token not issued for audience %q", oidc.config.Audience)
+		}
+	}
+
+	usernameClaim := oidc.config.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "sub"
+	}
+
+	username, _ := claimValue(tokenClaims, usernameClaim)
+	claims := &types.CyberoClaims{Provider: OIDCProvider}
+
+	if claims.Username, _ = username.(string); claims.Username == "" {
+		return nil, fmt.Errorf("token without %q claim", usernameClaim)
+	}
+
+	claims.Issuer = oidc.config.Issuer
+	claims.Subject, _ = tokenClaims["sub"].(string)
+	claims.Id, _ = tokenClaims["jti"].(string)
+	claims.ExpiresAt = int64(exp)
+
+	var roles []string
+	if oidc.config.RolesClaim != "" {
+		roles = claimStrings(tokenClaims, oidc.config.RolesClaim)
+	}
+
+	grantClaims(claims, roles)
+	return claims, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"cybero/types"
+	"errors"
+	"fmt"
+	"net"
+	"os/user"
+	"strconv"
+)
+
+// PeerCredProvider the provider recorded in the claims of local peers
+const PeerCredProvider = "peercred"
+
+type peerCredKey struct{}
+
+// peerCred the credentials of the process connected to a unix socket
+type peerCred struct {
+	pid int32
+	uid uint32
+	gid uint32
+}
+
+// peerConnContext store the peer credentials of unix socket connections
+// in the connection context
+func peerConnContext(ctx context.Context, conn net.Conn) context.Context {
+
+	logger := GetLogManager().GetLogger()
+
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	cred, err := peerCredentials(unixConn)
+	if err != nil {
+		logger.Printf("CyberoServer: Unable to get peer credentials: %v\n", err)
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
+
+// peerClaims returns the identity configured for the peer uid, or gid
+// if the uid is not allowed
+func peerClaims(ctx context.Context) (*types.CyberoClaims, error) {
+
+	cfg := GetConfigManager().serverConfig()
+
+	cred, ok := ctx.Value(peerCredKey{}).(*peerCred)
+	if !ok {
+		return nil, errors.New("No peer credentials available")
+	}
+
+	identity, ok := cfg.Auth.PeerCred.Uids[strconv.FormatUint(uint64(cred.uid), 10)]
+
+	if !ok {
+		identity, ok = cfg.Auth.PeerCred.Gids[strconv.FormatUint(uint64(cred.gid), 10)]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("Peer uid %d gid %d not allowed", cred.uid, cred.gid)
+	}
+
+	username := identity.Username
+
+	if username == "" {
+		username = fmt.Sprintf("uid:%d", cred.uid)
+		if localUser, err := user.LookupId(strconv.FormatUint(uint64(cred.uid), 10)); err == nil {
+			username = localUser.Username
+		}
+	}
+
+	claims := &types.CyberoClaims{Username: username, Provider: PeerCredProvider}
+	claims.Subject = fmt.Sprintf("pid:%d,uid:%d,gid:%d", cred.pid, cred.uid, cred.gid)
+
+	grantClaims(claims, identity.Roles)
+	return claims, nil
+}
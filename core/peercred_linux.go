@@ -0,0 +1,49 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package core
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials returns the credentials of the peer using SO_PEERCRED
+func peerCredentials(conn *net.UnixConn) (*peerCred, error) {
+
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if credErr != nil {
+		return nil, credErr
+	}
+
+	return &peerCred{pid: ucred.Pid, uid: ucred.Uid, gid: ucred.Gid}, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"cybero/types"
+)
+
+const (
+	// ScopeAll a scope granting access to every action
+	ScopeAll = "*"
+	// ScopeAuthAdmin a scope granting access to the auth administration actions
+	ScopeAuthAdmin = "auth:admin"
+	// ScopeAPIAdmin a scope granting access to the api administration actions
+	ScopeAPIAdmin = "api:admin"
+)
+
+// defaultPolicy the scopes required by actions without a configured policy
+var defaultPolicy = map[string][]string{
+	BuiltinModule + "/reload":  {ScopeAPIAdmin},
+	BuiltinModule + "/enable":  {ScopeAPIAdmin},
+	BuiltinModule + "/disable": {ScopeAPIAdmin},
+	BuiltinModule + "/reinit":  {ScopeAPIAdmin},
+}
+
+// appendUnique append the values not yet present in the list
+func appendUnique(list []string, values ...string) []string {
+
+	for _, value := range values {
+
+		found := false
+		for _, item := range list {
+			if item == value {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			list = append(list, value)
+		}
+	}
+
+	return list
+}
+
+// grantClaims set the roles of the claims, adding the roles configured
+// for the user, and the scopes granted by those roles
+func grantClaims(claims *types.CyberoClaims, roles []string) {
+
+	cfg := GetConfigManager().serverConfig()
+
+	claims.Roles = appendUnique(claims.Roles, roles...)
+	claims.Roles = appendUnique(claims.Roles, cfg.Policy.Users[claims.Username]...)
+
+	for _, role := range claims.Roles {
+		claims.Scopes = appendUnique(claims.Scopes, cfg.Policy.Roles[role]...)
+	}
+}
+
+// requiredScopes returns the scopes required to call an action of a module,
+// an action policy, configured or default, takes precedence over the module
+// policy
+func requiredScopes(module string, action string) []string {
+
+	cfg := GetConfigManager().serverConfig()
+
+	if scopes, ok := cfg.Policy.Actions[module+"/"+action]; ok && action != "" {
+		return scopes
+	}
+
+	if scopes, ok := defaultPolicy[module+"/"+action]; ok && action != "" {
+		return scopes
+	}
+
+	return cfg.Policy.Actions[module]
+}
+
+// hasScopes returns true if the claims hold all the scopes
+func hasScopes(claims *types.CyberoClaims, scopes []string) bool {
+
+	if len(scopes) == 0 {
+		return true
+	}
+
+	if claims == nil {
+		return false
+	}
+
+	for _, scope := range scopes {
+
+		found := false
+		for _, granted := range claims.Scopes {
+			if granted == scope || granted == ScopeAll {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,531 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"cybero/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Out of process modules are child processes serving HTTP on the unix
+// socket given by the CYBERO_SOCKET environment variable, their data
+// directory is given by CYBERO_DATA_DIR:
+//
+//	GET  /cybero/describe         {"name", "version", "info", "endpoint", "actions"}
+//	POST /cybero/initialize       the module "config" as a JSON object
+//	GET  /cybero/help?action=name {"help"}
+//	GET  /cybero/health           any status other than 200 is unhealthy
+//
+// Every other request is a API request, proxied without the credentials of
+// the caller and with its claims as JSON in the X-Cybero-Claims header.
+const (
+	// ProcessModuleKind the module kind of out of process modules
+	ProcessModuleKind = "process"
+	// ProcessSocketEnv the environment variable holding the socket path
+	ProcessSocketEnv = "CYBERO_SOCKET"
+	// ProcessDataDirEnv the environment variable holding the data directory
+	ProcessDataDirEnv = "CYBERO_DATA_DIR"
+	// ProcessClaimsHeader the header holding the claims of the caller
+	ProcessClaimsHeader = "X-Cybero-Claims"
+)
+
+var (
+	// ProcessStartTimeout how long a module process has to serve its socket
+	ProcessStartTimeout = 10 * time.Second
+	// ProcessMaxBackoff the longest wait before restarting a module process
+	ProcessMaxBackoff = time.Minute
+
+	errProcessDown = errors.New("module process is not running")
+)
+
+// processDescription what a module process reports about itself
+type processDescription struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Info     string   `json:"info"`
+	Endpoint string   `json:"endpoint"`
+	Actions  []string `json:"actions"`
+}
+
+// processModule a handler module running as a supervised child process
+type processModule struct {
+	sync.RWMutex
+	name        string
+	config      types.CyberoModuleConfig
+	socket      string
+	dataDir     string
+	logger      *log.Logger
+	client      *http.Client
+	proxy       *httputil.ReverseProxy
+	description processDescription
+	initialized bool
+	running     bool
+	cmd         *exec.Cmd
+	exited      chan struct{}
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// processModuleFiles returns the modules configured to run as processes
+func processModuleFiles() map[string]moduleFile {
+
+	files := make(map[string]moduleFile)
+
+	for name, config := range GetConfigManager().moduleConfigs() {
+		if config.Kind == ProcessModuleKind && config.Enabled {
+			files[name] = moduleFile{name: name, module: newProcessModule(name, config)}
+		}
+	}
+
+	return files
+}
+
+// newProcessModule create a module for a configured process
+func newProcessModule(name string, config types.CyberoModuleConfig) *processModule {
+
+	module := &processModule{
+		name:   name,
+		config: config,
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+
+			module.RLock()
+			socket := module.socket
+			module.RUnlock()
+
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+
+	module.client = &http.Client{Transport: transport, Timeout: ProcessStartTimeout}
+
+	target := &url.URL{Scheme: "http", Host: name}
+	module.proxy = httputil.NewSingleHostReverseProxy(target)
+	module.proxy.Transport = transport
+	module.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		module.logger.Printf("ProcessModule: Error proxying request to %q: %v\n", name, err)
+		sendResponse(w, http.StatusServiceUnavailable, -1, map[string]interface{}{"Error": errProcessDown.Error()})
+	}
+
+	return module
+}
+
+// InitializeContext start the process with the module data directory
+func (module *processModule) InitializeContext(context types.CyberoModuleContext) error {
+
+	module.Lock()
+	module.dataDir = context.DataDir()
+	module.Unlock()
+
+	return module.Initialize(context.Logger(), context.Config())
+}
+
+// Initialize start the process and initialize it with config
+func (module *processModule) Initialize(logger *log.Logger, config map[string]interface{}) error {
+
+	module.Lock()
+	module.logger = logger
+	module.config.Config = config
+	module.Unlock()
+
+	if module.config.Command == "" {
+		return fmt.Errorf("module %q has no command", module.name)
+	}
+
+	// Only the server user can connect to the socket
+	dir, err := ioutil.TempDir("", "cybero-"+module.name+"-")
+	if err != nil {
+		return err
+	}
+
+	module.Lock()
+	module.socket = filepath.Join(dir, "module.sock")
+	module.Unlock()
+
+	if err := module.start(); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	module.initialized = true
+	module.stop = make(chan struct{})
+	module.done = make(chan struct{})
+
+	go module.supervise()
+	return nil
+}
+
+// start launch the process, wait for its socket and initialize it
+func (module *processModule) start() error {
+
+	os.Remove(module.socket)
+
+	module.RLock()
+	cmd := exec.Command(module.config.Command, module.config.Args...)
+	cmd.Env = append(os.Environ(), ProcessSocketEnv+"="+module.socket)
+	if module.dataDir != "" {
+		cmd.Env = append(cmd.Env, ProcessDataDirEnv+"="+module.dataDir)
+	}
+	output := &processOutput{logger: module.logger}
+	cmd.Stdout, cmd.Stderr = output, output
+	module.RUnlock()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		output.flush()
+		close(exited)
+	}()
+
+	err := module.handshake(exited)
+
+	if err != nil {
+		cmd.Process.Kill()
+		<-exited
+		return err
+	}
+
+	module.Lock()
+	module.cmd, module.exited, module.running = cmd, exited, true
+	module.Unlock()
+
+	module.logger.Printf("ProcessModule: Module %q running, pid: %d\n", module.name, cmd.Process.Pid)
+	return nil
+}
+
+// handshake wait for the process to describe itself and initialize it
+func (module *processModule) handshake(exited chan struct{}) error {
+
+	deadline := time.Now().Add(ProcessStartTimeout)
+	var description processDescription
+
+	for {
+
+		err := module.call(http.MethodGet, "/cybero/describe", nil, &description)
+		if err == nil {
+			break
+		}
+
+		select {
+		case <-exited:
+			return errors.New("module process exited while starting")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("module process not ready: %v", err)
+		}
+	}
+
+	if description.Endpoint == "" {
+		description.Endpoint = module.name
+	}
+
+	// Restarted processes keep serving the endpoint they were registered on
+	module.Lock()
+	if module.description.Endpoint != "" && description.Endpoint != module.description.Endpoint {
+		module.Unlock()
+		return fmt.Errorf("module process endpoint changed to %q", description.Endpoint)
+	}
+	module.description = description
+	config := module.config.Config
+	module.Unlock()
+
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+
+	return module.call(http.MethodPost, "/cybero/initialize", config, nil)
+}
+
+// processOutput log the output of a process line by line with the module
+// logger
+type processOutput struct {
+	logger  *log.Logger
+	pending []byte
+}
+
+func (output *processOutput) Write(data []byte) (int, error) {
+
+	output.pending = append(output.pending, data...)
+
+	for {
+		end := bytes.IndexByte(output.pending, '\n')
+		if end < 0 {
+			break
+		}
+
+		output.logger.Println(string(output.pending[:end]))
+		output.pending = output.pending[end+1:]
+	}
+
+	return len(data), nil
+}
+
+// flush log the last line if it was not terminated
+func (output *processOutput) flush() {
+
+	if len(output.pending) > 0 {
+		output.logger.Println(string(output.pending))
+		output.pending = nil
+	}
+}
+
+// call make a protocol request to the process
+func (module *processModule) call(method string, path string, in interface{}, out interface{}) error {
+
+	var body bytes.Buffer
+
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, "http://"+module.name+path, &body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := module.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	return nil
+}
+
+// supervise restart the process when it exits, waiting longer after each
+// failed restart
+func (module *processModule) supervise() {
+
+	defer close(module.done)
+
+	backoff := time.Second
+
+	for {
+
+		module.RLock()
+		exited := module.exited
+		module.RUnlock()
+
+		started := time.Now()
+
+		select {
+		case <-module.stop:
+			return
+		case <-exited:
+		}
+
+		module.Lock()
+		module.running = false
+		module.Unlock()
+
+		// A process that ran for a while is restarted right away
+		if time.Since(started) > ProcessMaxBackoff {
+			backoff = time.Second
+		}
+
+		for {
+
+			module.logger.Printf("ProcessModule: Module %q exited, restarting in %v\n", module.name, backoff)
+
+			select {
+			case <-module.stop:
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > ProcessMaxBackoff {
+				backoff = ProcessMaxBackoff
+			}
+
+			err := module.start()
+			if err == nil {
+				break
+			}
+
+			module.logger.Printf("ProcessModule: Error restarting module %q: %v\n", module.name, err)
+		}
+	}
+}
+
+// Shutdown stop supervising and terminate the process, it is killed if it
+// does not exit before the context is done
+func (module *processModule) Shutdown(ctx context.Context) error {
+
+	if !module.initialized {
+		return nil
+	}
+
+	module.initialized = false
+
+	close(module.stop)
+	<-module.done
+
+	module.Lock()
+	module.running = false
+	cmd, exited := module.cmd, module.exited
+	module.Unlock()
+
+	defer os.RemoveAll(filepath.Dir(module.socket))
+
+	cmd.Process.Signal(syscall.SIGTERM)
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-exited
+		return ctx.Err()
+	}
+}
+
+// Health check the process reports itself healthy
+func (module *processModule) Health() error {
+
+	module.RLock()
+	running := module.running
+	module.RUnlock()
+
+	if !running {
+		return errProcessDown
+	}
+
+	return module.call(http.MethodGet, "/cybero/health", nil, nil)
+}
+
+// IsInitialized returns if the process was started
+func (module *processModule) IsInitialized() bool {
+	return module.initialized
+}
+
+// Name returns the configured module name
+func (module *processModule) Name() string {
+	return module.name
+}
+
+// Version returns the version reported by the process
+func (module *processModule) Version() string {
+	module.RLock()
+	defer module.RUnlock()
+	return module.description.Version
+}
+
+// Info returns the info reported by the process
+func (module *processModule) Info() string {
+	module.RLock()
+	defer module.RUnlock()
+	return module.description.Info
+}
+
+// Endpoint returns the endpoint reported by the process
+func (module *processModule) Endpoint() string {
+	module.RLock()
+	defer module.RUnlock()
+	return module.description.Endpoint
+}
+
+// Actions returns the actions reported by the process
+func (module *processModule) Actions() map[string]interface{} {
+
+	module.RLock()
+	defer module.RUnlock()
+
+	actions := make(map[string]interface{}, len(module.description.Actions))
+	for _, action := range module.description.Actions {
+		actions[action] = nil
+	}
+
+	return actions
+}
+
+// Help returns the help of an action from the process
+func (module *processModule) Help(action string) string {
+
+	var help struct {
+		Help string `json:"help"`
+	}
+
+	if err := module.call(http.MethodGet, "/cybero/help?action="+url.QueryEscape(action), nil, &help); err != nil {
+		return ""
+	}
+
+	return help.Help
+}
+
+// HandleRequest proxy the request to the process, the caller claims are
+// passed in a header no client can set
+func (module *processModule) HandleRequest(w http.ResponseWriter, r *http.Request) error {
+
+	module.RLock()
+	running := module.running
+	module.RUnlock()
+
+	if !running {
+		sendResponse(w, http.StatusServiceUnavailable, -1, map[string]interface{}{"Error": errProcessDown.Error()})
+		return nil
+	}
+
+	// The process gets the identity of the caller, never its credentials
+	r = r.Clone(r.Context())
+	r.Header.Del(ProcessClaimsHeader)
+	r.Header.Del("Authorization")
+	r.Header.Del(APIKeyHeader)
+
+	if claims, ok := types.GetClaims(r.Context()); ok {
+		if encoded, err := json.Marshal(claims); err == nil {
+			r.Header.Set(ProcessClaimsHeader, string(encoded))
+		}
+	}
+
+	module.proxy.ServeHTTP(w, r)
+	return nil
+}
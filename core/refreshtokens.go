@@ -0,0 +1,258 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto/sha256"
+	"cybero/types"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	errRefreshTokenInvalid  = errors.New("invalid refresh token")
+	errRefreshTokenExpired  = errors.New("refresh token expired")
+	errRefreshTokenReplayed = errors.New("refresh token replayed")
+)
+
+// refreshToken a issued refresh token, tokens issued from the same
+// signin share the same family
+type refreshToken struct {
+	claims  types.CyberoClaims
+	family  string
+	expires time.Time
+	used    bool
+}
+
+// refreshTokenStore holds the issued refresh tokens, tokens are kept
+// by their hash so the store never holds usable tokens. The ids of the
+// access tokens issued with each family are kept so they are revoked with
+// the family
+type refreshTokenStore struct {
+	sync.Mutex
+	tokens   map[string]*refreshToken
+	families map[string]map[string]int64
+	revoked  *revocationStore
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshTokenStore(revoked *revocationStore) *refreshTokenStore {
+	return &refreshTokenStore{
+		tokens:   make(map[string]*refreshToken),
+		families: make(map[string]map[string]int64),
+		revoked:  revoked,
+	}
+}
+
+// issue creates a new refresh token for the claims, an empty family
+// starts a new one. The access token of the claims joins the family
+func (store *refreshTokenStore) issue(claims types.CyberoClaims, family string) (string, time.Time, error) {
+
+	token, err := randomString(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if family == "" {
+		if family, err = randomString(16); err != nil {
+			return "", time.Time{}, err
+		}
+	}
+
+	accessID, accessExpires := claims.Id, claims.ExpiresAt
+
+	// Only the identity is kept, the standard claims are set on issue
+	claims.StandardClaims = jwt.StandardClaims{}
+	expires := time.Now().Add(refreshExpiration())
+
+	store.Lock()
+	defer store.Unlock()
+
+	store.prune()
+	store.addAccessToken(family, accessID, accessExpires)
+	store.tokens[hashToken(token)] = &refreshToken{
+		claims:  claims,
+		family:  family,
+		expires: expires,
+	}
+
+	return token, expires, nil
+}
+
+// rotate consumes a refresh token and issues a new one on the same family,
+// using an already consumed token revokes the whole family
+func (store *refreshTokenStore) rotate(token string) (types.CyberoClaims, string, time.Time, error) {
+
+	store.Lock()
+	entry, ok := store.tokens[hashToken(token)]
+
+	if !ok {
+		store.Unlock()
+		return types.CyberoClaims{}, "", time.Time{}, errRefreshTokenInvalid
+	}
+
+	if entry.used {
+		accessTokens := store.revokeFamily(entry.family)
+		store.Unlock()
+		store.revokeAccessTokens(accessTokens)
+		return entry.claims, "", time.Time{}, errRefreshTokenReplayed
+	}
+
+	if time.Now().After(entry.expires) {
+		delete(store.tokens, hashToken(token))
+		store.Unlock()
+		return entry.claims, "", time.Time{}, errRefreshTokenExpired
+	}
+
+	entry.used = true
+	store.Unlock()
+
+	newToken, expires, err := store.issue(entry.claims, entry.family)
+	return entry.claims, newToken, expires, err
+}
+
+// track add the access token issued with a rotated refresh token to the
+// family of the refresh token
+func (store *refreshTokenStore) track(token string, claims types.CyberoClaims) {
+
+	store.Lock()
+	defer store.Unlock()
+
+	if entry, ok := store.tokens[hashToken(token)]; ok {
+		store.addAccessToken(entry.family, claims.Id, claims.ExpiresAt)
+	}
+}
+
+// replace add a refreshed access token to the family of the token it
+// replaces
+func (store *refreshTokenStore) replace(id string, claims types.CyberoClaims) {
+
+	store.Lock()
+	defer store.Unlock()
+
+	for family, accessTokens := range store.families {
+		if _, ok := accessTokens[id]; ok {
+			store.addAccessToken(family, claims.Id, claims.ExpiresAt)
+			return
+		}
+	}
+}
+
+// addAccessToken add an access token to a family, must be called locked
+func (store *refreshTokenStore) addAccessToken(family string, id string, expiresAt int64) {
+
+	if id == "" {
+		return
+	}
+
+	if store.families[family] == nil {
+		store.families[family] = make(map[string]int64)
+	}
+
+	store.families[family][id] = expiresAt
+}
+
+// revoke removes the token and every token of its family, the access
+// tokens of the family are revoked
+func (store *refreshTokenStore) revoke(token string) {
+
+	store.Lock()
+
+	var accessTokens map[string]int64
+	if entry, ok := store.tokens[hashToken(token)]; ok {
+		accessTokens = store.revokeFamily(entry.family)
+	}
+
+	store.Unlock()
+
+	store.revokeAccessTokens(accessTokens)
+}
+
+// revokeFamily removes every token of a family and returns the access
+// tokens issued with it, must be called locked
+func (store *refreshTokenStore) revokeFamily(family string) map[string]int64 {
+
+	for key, entry := range store.tokens {
+		if entry.family == family {
+			delete(store.tokens, key)
+		}
+	}
+
+	accessTokens := store.families[family]
+	delete(store.families, family)
+
+	return accessTokens
+}
+
+// revokeAccessTokens revoke access tokens, for as long as they can be
+// refreshed
+func (store *refreshTokenStore) revokeAccessTokens(accessTokens map[string]int64) {
+
+	logger := GetLogManager().GetLogger()
+
+	for id, expiresAt := range accessTokens {
+
+		revokeUntil := time.Unix(expiresAt, 0).Add(refreshGrace()).Unix()
+
+		if err := store.revoked.revoke(id, revokeUntil); err != nil {
+			logger.Printf("Auth: Error persisting revoked token %q: %v\n", id, err)
+		}
+	}
+}
+
+// collect removes expired tokens
+func (store *refreshTokenStore) collect() {
+
+	store.Lock()
+	defer store.Unlock()
+
+	store.prune()
+}
+
+// prune removes expired tokens and the access tokens no longer usable,
+// must be called locked
+func (store *refreshTokenStore) prune() {
+
+	now := time.Now()
+
+	for key, entry := range store.tokens {
+		if now.After(entry.expires) {
+			delete(store.tokens, key)
+		}
+	}
+
+	grace := int64(refreshGrace().Seconds())
+
+	for family, accessTokens := range store.families {
+
+		for id, expiresAt := range accessTokens {
+			if expiresAt+grace < now.Unix() {
+				delete(accessTokens, id)
+			}
+		}
+
+		if len(accessTokens) == 0 {
+			delete(store.families, family)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// revocationStore holds the ids of revoked tokens until they expire,
+// optionally persisted to a file so revocations survive restarts
+type revocationStore struct {
+	sync.Mutex
+	revoked map[string]int64
+	file    string
+}
+
+func newRevocationStore(file string) *revocationStore {
+	return &revocationStore{revoked: make(map[string]int64), file: file}
+}
+
+// load read the revoked tokens from the store file
+func (store *revocationStore) load() error {
+
+	if store.file == "" {
+		return nil
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	return readJSONFile(store.file, &store.revoked)
+}
+
+// save write the revoked tokens to the store file, must be called locked
+func (store *revocationStore) save() error {
+
+	if store.file == "" {
+		return nil
+	}
+
+	return writeJSONFile(store.file, store.revoked, 0600)
+}
+
+// revoke mark a token id as revoked until it expires
+func (store *revocationStore) revoke(id string, expiresAt int64) error {
+
+	store.Lock()
+	defer store.Unlock()
+
+	store.revoked[id] = expiresAt
+	return store.save()
+}
+
+// revokeIfAbsent mark a token id as revoked unless it already is, returns
+// false if it was
+func (store *revocationStore) revokeIfAbsent(id string, expiresAt int64) (bool, error) {
+
+	store.Lock()
+	defer store.Unlock()
+
+	if _, ok := store.revoked[id]; ok {
+		return false, nil
+	}
+
+	store.revoked[id] = expiresAt
+	return true, store.save()
+}
+
+// isRevoked returns true if the token id was revoked
+func (store *revocationStore) isRevoked(id string) bool {
+
+	store.Lock()
+	defer store.Unlock()
+
+	_, ok := store.revoked[id]
+	return ok
+}
+
+// prune removes the revocations of tokens already expired
+func (store *revocationStore) prune() error {
+
+	store.Lock()
+	defer store.Unlock()
+
+	now := time.Now().Unix()
+	pruned := false
+
+	for id, expiresAt := range store.revoked {
+		if expiresAt < now {
+			delete(store.revoked, id)
+			pruned = true
+		}
+	}
+
+	if !pruned {
+		return nil
+	}
+
+	return store.save()
+}
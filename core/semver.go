@@ -0,0 +1,290 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semVersion a semantic version, major.minor.patch[-prerelease], parts
+// is how many of the numbers were given
+type semVersion struct {
+	major      int
+	minor      int
+	patch      int
+	prerelease string
+	parts      int
+}
+
+// parseVersion parse a semantic version, missing minor and patch are
+// zero and a leading "v" is allowed
+func parseVersion(version string) (semVersion, error) {
+
+	var parsed semVersion
+
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+
+	// Build metadata does not take part in comparisons
+	if index := strings.Index(version, "+"); index >= 0 {
+		version = version[:index]
+	}
+
+	if index := strings.Index(version, "-"); index >= 0 {
+		parsed.prerelease = version[index+1:]
+		version = version[:index]
+	}
+
+	parts := strings.Split(version, ".")
+
+	if len(parts) > 3 || parts[0] == "" {
+		return parsed, fmt.Errorf("invalid version %q", version)
+	}
+
+	numbers := []*int{&parsed.major, &parsed.minor, &parsed.patch}
+
+	for i, part := range parts {
+
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return parsed, fmt.Errorf("invalid version %q", version)
+		}
+
+		*numbers[i] = number
+	}
+
+	parsed.parts = len(parts)
+	return parsed, nil
+}
+
+// compare returns -1, 0 or 1 if the version is lower, equal or greater
+func (version semVersion) compare(other semVersion) int {
+
+	pairs := [][2]int{
+		{version.major, other.major},
+		{version.minor, other.minor},
+		{version.patch, other.patch},
+	}
+
+	for _, pair := range pairs {
+		if pair[0] < pair[1] {
+			return -1
+		}
+		if pair[0] > pair[1] {
+			return 1
+		}
+	}
+
+	// A prerelease is lower than the release
+	switch {
+	case version.prerelease == other.prerelease:
+		return 0
+	case version.prerelease == "":
+		return 1
+	case other.prerelease == "":
+		return -1
+	}
+
+	return comparePrerelease(version.prerelease, other.prerelease)
+}
+
+// comparePrerelease compare the dot separated identifiers of prereleases,
+// numeric identifiers compare numerically and are lower than the others,
+// a prerelease with less identifiers is lower when they are all equal
+func comparePrerelease(a string, b string) int {
+
+	fieldsA, fieldsB := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(fieldsA) && i < len(fieldsB); i++ {
+
+		numberA, errA := strconv.Atoi(fieldsA[i])
+		numberB, errB := strconv.Atoi(fieldsB[i])
+
+		switch {
+		case errA == nil && errB == nil && numberA != numberB:
+			if numberA < numberB {
+				return -1
+			}
+			return 1
+		case errA == nil && errB != nil:
+			return -1
+		case errA != nil && errB == nil:
+			return 1
+		case errA != nil && errB != nil && fieldsA[i] != fieldsB[i]:
+			return strings.Compare(fieldsA[i], fieldsB[i])
+		}
+	}
+
+	switch {
+	case len(fieldsA) < len(fieldsB):
+		return -1
+	case len(fieldsA) > len(fieldsB):
+		return 1
+	}
+
+	return 0
+}
+
+// compareVersions compare two version strings, invalid versions are
+// lower than any valid version
+func compareVersions(a string, b string) int {
+
+	versionA, errA := parseVersion(a)
+	versionB, errB := parseVersion(b)
+
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+
+	return versionA.compare(versionB)
+}
+
+// versionComparator a single comparison of a version constraint
+type versionComparator struct {
+	operator string
+	version  semVersion
+}
+
+// versionConstraint a semantic version range, a set of alternatives each
+// requiring all its comparators to match
+type versionConstraint [][]versionComparator
+
+// parseConstraint parse a version range, comparators (>=, <=, >, <, =, ^
+// and ~) separated by spaces must all match, alternatives are separated
+// by "||". An empty range or "*" matches any version
+func parseConstraint(constraint string) (versionConstraint, error) {
+
+	var parsed versionConstraint
+
+	for _, alternative := range strings.Split(constraint, "||") {
+
+		comparators := []versionComparator{}
+
+		for _, field := range strings.Fields(alternative) {
+
+			if field == "*" {
+				continue
+			}
+
+			expanded, err := parseComparator(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version range %q: %v", constraint, err)
+			}
+
+			comparators = append(comparators, expanded...)
+		}
+
+		parsed = append(parsed, comparators)
+	}
+
+	return parsed, nil
+}
+
+// parseComparator parse a comparator, caret and tilde ranges expand into a
+// lower and upper bound
+func parseComparator(field string) ([]versionComparator, error) {
+
+	operator := ""
+
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(field, prefix) {
+			operator = prefix
+			break
+		}
+	}
+
+	version, err := parseVersion(strings.TrimPrefix(field, operator))
+	if err != nil {
+		return nil, err
+	}
+
+	switch operator {
+	case "", "=":
+		return []versionComparator{{"=", version}}, nil
+	case "^":
+		// Changes that do not modify the left-most non-zero number, a
+		// missing number is any
+		upper := semVersion{major: version.major + 1}
+		switch {
+		case version.major != 0 || version.parts == 1:
+		case version.minor != 0 || version.parts == 2:
+			upper = semVersion{minor: version.minor + 1}
+		default:
+			upper = semVersion{minor: version.minor, patch: version.patch + 1}
+		}
+		return []versionComparator{{">=", version}, {"<", upper}}, nil
+	case "~":
+		// Patch level changes, or minor ones if the minor is missing
+		upper := semVersion{major: version.major, minor: version.minor + 1}
+		if version.parts == 1 {
+			upper = semVersion{major: version.major + 1}
+		}
+		return []versionComparator{{">=", version}, {"<", upper}}, nil
+	}
+
+	return []versionComparator{{operator, version}}, nil
+}
+
+// matches check if a version matches the comparator
+func (comparator versionComparator) matches(version semVersion) bool {
+
+	result := version.compare(comparator.version)
+
+	switch comparator.operator {
+	case ">=":
+		return result >= 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case "<":
+		return result < 0
+	}
+
+	return result == 0
+}
+
+// allows check if a version is within the range
+func (constraint versionConstraint) allows(version string) bool {
+
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return false
+	}
+
+	for _, alternative := range constraint {
+
+		matched := true
+
+		for _, comparator := range alternative {
+			if !comparator.matches(parsed) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.2", "1.2.0", 0},
+		{"1.0.0", "2.0.0", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.1", "1.0.0", 1},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0+build", "1.0.0", 0},
+		{"1.0.0-alpha.2", "1.0.0-alpha.10", -1},
+		{"1.0.0-alpha.10", "1.0.0-alpha.2", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta", "1.0.0-alpha.beta", 1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+		{"invalid", "1.0.0", -1},
+		{"1.0.0", "invalid", 1},
+	}
+
+	for _, test := range tests {
+		if got := compareVersions(test.a, test.b); got != test.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestConstraintAllows(t *testing.T) {
+
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"", "1.2.3", true},
+		{"*", "0.0.1", true},
+		{"1.2.3", "1.2.3", true},
+		{"=1.2.3", "1.2.4", false},
+		{">=1.2.0", "1.2.0", true},
+		{">=1.2.0", "1.1.9", false},
+		{">1.2.0 <2.0.0", "1.5.0", true},
+		{">1.2.0 <2.0.0", "2.0.0", false},
+		{"<=1.0.0", "1.0.0", true},
+		{"<1.0.0 || >=2.0.0", "1.5.0", false},
+		{"<1.0.0 || >=2.0.0", "2.1.0", true},
+
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "1.2.2", false},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^0.0.3", "0.0.9", false},
+		{"^1", "1.9.9", true},
+		{"^1", "2.0.0", false},
+		{"^0", "0.9.0", true},
+		{"^0", "1.0.0", false},
+		{"^0.0", "0.0.9", true},
+		{"^0.0", "0.1.0", false},
+		{"^1.2", "1.9.0", true},
+		{"^1.2", "2.0.0", false},
+
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1.2", "1.2.0", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.5.0", true},
+		{"~1", "2.0.0", false},
+		{"~0.2.3", "0.2.5", true},
+		{"~0.2.3", "0.3.0", false},
+	}
+
+	for _, test := range tests {
+
+		constraint, err := parseConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("parseConstraint(%q): %v", test.constraint, err)
+			continue
+		}
+
+		if got := constraint.allows(test.version); got != test.want {
+			t.Errorf("%q allows %q = %v, want %v", test.constraint, test.version, got, test.want)
+		}
+	}
+}
+
+func TestParseConstraintInvalid(t *testing.T) {
+
+	for _, constraint := range []string{"^x", ">=1.2.3.4", "~", "1.a"} {
+		if _, err := parseConstraint(constraint); err == nil {
+			t.Errorf("parseConstraint(%q) did not fail", constraint)
+		}
+	}
+}
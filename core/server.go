@@ -17,13 +17,17 @@ package core
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"cybero/types"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -38,9 +42,14 @@ func (rest *CyberoServer) Initialize() error {
 
 	var err error
 
-	cfg := GetConfigManager().GetConfig()
+	cfg := GetConfigManager().serverConfig()
 	logger := GetLogManager().GetLogger()
 
+	if err := GetAuthManager().keysErr; err != nil {
+		logger.Printf("CyberoServer: Failed to load signing keys: %v\n", err)
+		return err
+	}
+
 	// get the socket to listen
 	socket := cfg.Socket
 
@@ -75,7 +84,13 @@ func (rest *CyberoServer) Initialize() error {
 	}
 
 	// Load configured modules
-	GetModuleManager().LoadModules()
+	if err := GetModuleManager().LoadModules(); err != nil {
+		logger.Printf("CyberoServer: Failed to load modules: %v\n", err)
+		return err
+	}
+
+	// Reload modules on SIGHUP
+	go rest.watchReload()
 
 	// Assign internal endpoints
 	rest.APIHandler(AuthEndpoint, GetAuthManager().HandleRequest)
@@ -83,6 +98,20 @@ func (rest *CyberoServer) Initialize() error {
 	return nil
 }
 
+// watchReload reload the modules each time the server receives a SIGHUP
+func (rest *CyberoServer) watchReload() {
+
+	logger := GetLogManager().GetLogger()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		logger.Println("CyberoServer: SIGHUP received, reloading modules")
+		GetModuleManager().ReloadModules()
+	}
+}
+
 // APIHandler Add a new handler
 func (rest *CyberoServer) APIHandler(url string, handler types.CyberoHandler) {
 	if rest.serverEndpoints == nil {
@@ -119,6 +148,20 @@ func (rest *CyberoServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// sendResponse write a CyberoResponse with the given http status
+func sendResponse(w http.ResponseWriter, status int, code int, msg map[string]interface{}) {
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.Encode(types.CyberoResponse{
+		"Status":   code,
+		"Response": msg,
+	})
+}
+
 // listenUnixSocket start API listener on a unix socket
 func (rest *CyberoServer) listenUnixSocket(socket string) error {
 
@@ -139,6 +182,7 @@ func (rest *CyberoServer) listenUnixSocket(socket string) error {
 
 	server := http.Server{
 		Handler:      rest,
+		ConnContext:  peerConnContext,
 		ErrorLog:     logger,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
@@ -174,6 +218,42 @@ func (rest *CyberoServer) listenTCPSocket(address string) error {
 	return nil
 }
 
+// setupClientAuth configure the verification of client certificates, with
+// "verify" certificates are verified if given and with "require" every
+// client must present a valid certificate
+func setupClientAuth(config *tls.Config) error {
+
+	cfg := GetConfigManager().serverConfig()
+
+	switch cfg.ClientAuth {
+	case "", "none":
+		return nil
+	case "verify":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("invalid client authentication mode %q", cfg.ClientAuth)
+	}
+
+	if cfg.ClientCA == "" {
+		return fmt.Errorf("client authentication mode %q requires a client CA", cfg.ClientAuth)
+	}
+
+	data, err := ioutil.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in %q", cfg.ClientCA)
+	}
+
+	config.ClientCAs = pool
+	return nil
+}
+
 // listenTCPSocketTLS Listen API in a TCP socket
 func (rest *CyberoServer) listenTCPSocketTLS(address string, pemFile string, keyFile string) error {
 
@@ -185,7 +265,12 @@ func (rest *CyberoServer) listenTCPSocketTLS(address string, pemFile string, key
 		return err
 	}
 
-	config := tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true}
+	config := tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if err := setupClientAuth(&config); err != nil {
+		logger.Printf("Failed to setup client authentication: %v\n", err)
+		return err
+	}
 
 	listener, err := tls.Listen("tcp", address, &config)
 
@@ -211,7 +296,7 @@ func (rest *CyberoServer) listenTCPSocketTLS(address string, pemFile string, key
 func (rest *CyberoServer) Shutdown() {
 
 	logger := GetLogManager().GetLogger()
-	cfg := GetConfigManager().GetConfig()
+	cfg := GetConfigManager().serverConfig()
 
 	// Do a gracefull shutdown of the server
 	logger.Println("Server is shutting down...")
@@ -225,6 +310,9 @@ func (rest *CyberoServer) Shutdown() {
 		logger.Fatalf("Could not gracefully shutdown the TCP server: %v\n", err)
 	}
 
+	// Release the modules resources once no more requests are running
+	GetModuleManager().Shutdown(ctx)
+
 	// get the socket to listen
 	socket := cfg.Socket
 
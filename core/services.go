@@ -0,0 +1,183 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"cybero/types"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// serviceRegistry holds the services published by the loaded modules
+type serviceRegistry struct {
+	sync.RWMutex
+	services map[string]*publishedService
+}
+
+// publishedService a service and the module instance providing it
+type publishedService struct {
+	provider *moduleServices
+	service  interface{}
+}
+
+// moduleServices the registry as seen by a module, services published are
+// withdrawn when the module is unloaded. Services published while the module
+// loads are staged until it is registered
+type moduleServices struct {
+	registry  *serviceRegistry
+	name      string
+	disabled  bool
+	committed bool
+	staged    map[string]interface{}
+}
+
+// newServiceRegistry create a empty registry
+func newServiceRegistry() *serviceRegistry {
+	return &serviceRegistry{services: make(map[string]*publishedService)}
+}
+
+// forModule returns the registry view of a module
+func (registry *serviceRegistry) forModule(name string) *moduleServices {
+	return &moduleServices{registry: registry, name: name, staged: make(map[string]interface{})}
+}
+
+// commit publish the services staged by a registered module instance, they
+// replace the ones of the previous instance
+func (registry *serviceRegistry) commit(provider *moduleServices) {
+
+	if provider == nil {
+		return
+	}
+
+	logger := GetLogManager().GetLogger()
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	for name, service := range provider.staged {
+
+		// Published by another module while this one was loading
+		if published, ok := registry.services[name]; ok && published.provider.name != provider.name {
+			logger.Printf("ModulesManager: Service %q of module %q not published, already published by module %q\n", name, provider.name, published.provider.name)
+			continue
+		}
+
+		registry.services[name] = &publishedService{provider: provider, service: service}
+	}
+
+	provider.committed = true
+	provider.staged = nil
+}
+
+// withdraw remove the services published by a module instance
+func (registry *serviceRegistry) withdraw(provider *moduleServices) {
+
+	if provider == nil {
+		return
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	for name, published := range registry.services {
+		if published.provider == provider {
+			delete(registry.services, name)
+		}
+	}
+}
+
+// setDisabled make the services of a module instance unavailable while it
+// is disabled
+func (registry *serviceRegistry) setDisabled(provider *moduleServices, disabled bool) {
+
+	if provider == nil {
+		return
+	}
+
+	registry.Lock()
+	provider.disabled = disabled
+	registry.Unlock()
+}
+
+// Publish make a service available to the other modules, a new version of
+// a module takes over the services of the previous one
+func (services *moduleServices) Publish(name string, service interface{}) error {
+
+	if service == nil {
+		return fmt.Errorf("service %q is nil", name)
+	}
+
+	registry := services.registry
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if published, ok := registry.services[name]; ok && published.provider.name != services.name {
+		return fmt.Errorf("service %q already published by module %q", name, published.provider.name)
+	}
+
+	// The previous version keeps serving until this one is registered
+	if !services.committed {
+		services.staged[name] = service
+		return nil
+	}
+
+	registry.services[name] = &publishedService{provider: services, service: service}
+	return nil
+}
+
+// Lookup set target, a pointer to the service interface, to the service
+// published with name
+func (services *moduleServices) Lookup(name string, target interface{}) error {
+
+	value := reflect.ValueOf(target)
+
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("service %q lookup target must be a non nil pointer", name)
+	}
+
+	registry := services.registry
+
+	registry.RLock()
+	published, ok := registry.services[name]
+	disabled := ok && published.provider.disabled
+	registry.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("service %q is not available, is the providing module enabled?", name)
+	}
+
+	if disabled {
+		return fmt.Errorf("service %q is not available, module %q is disabled", name, published.provider.name)
+	}
+
+	service := reflect.ValueOf(published.service)
+
+	if !service.Type().AssignableTo(value.Elem().Type()) {
+		return fmt.Errorf("service %q of type %s is not a %s", name, service.Type(), value.Elem().Type())
+	}
+
+	value.Elem().Set(service)
+	return nil
+}
+
+// setServices give a module its registry view before it is initialized
+func setServices(moduleImpl interface{}, services types.CyberoServiceRegistry) {
+
+	if module, ok := moduleImpl.(types.CyberoServiceModule); ok {
+		module.SetServices(services)
+	}
+}
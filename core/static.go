@@ -0,0 +1,63 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"cybero/types"
+	"sync"
+)
+
+var (
+	staticModulesLock sync.Mutex
+	staticModules     = make(map[string]types.CyberoModule)
+)
+
+// RegisterModule register a module compiled into the binary, usually from
+// an init function. The module is configured by the modules configuration
+// entry with the same name and loaded like a plugin when enabled.
+// It panics if the name is registered twice or the module is not a
+// handler or an authentication module
+func RegisterModule(name string, module types.CyberoModule) {
+
+	staticModulesLock.Lock()
+	defer staticModulesLock.Unlock()
+
+	switch module.(type) {
+	case types.CyberoHandlerModule, types.CyberoAuthModule:
+	default:
+		panic("core: RegisterModule module " + name + " is not a Cybero handler or auth provider")
+	}
+
+	if _, ok := staticModules[name]; ok {
+		panic("core: RegisterModule called twice for module " + name)
+	}
+
+	staticModules[name] = module
+}
+
+// staticModuleFiles returns the statically linked modules
+func staticModuleFiles() map[string]moduleFile {
+
+	staticModulesLock.Lock()
+	defer staticModulesLock.Unlock()
+
+	files := make(map[string]moduleFile, len(staticModules))
+
+	for name, module := range staticModules {
+		files[name] = moduleFile{name: name, version: module.Version(), module: module}
+	}
+
+	return files
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto/rand"
+	"cybero/types"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// TokenIssuer the issuer of the tokens signed by the server
+	TokenIssuer = "cybero"
+
+	defaultTokenExpiration   = 5 * time.Minute
+	defaultRefreshGrace      = 2 * time.Minute
+	defaultRefreshExpiration = 24 * time.Hour
+)
+
+// tokenExpiration returns the configured lifetime of a token
+func tokenExpiration() time.Duration {
+
+	cfg := GetConfigManager().serverConfig()
+
+	if cfg.Auth.Expiration > 0 {
+		return time.Duration(cfg.Auth.Expiration) * time.Minute
+	}
+
+	return defaultTokenExpiration
+}
+
+// refreshGrace returns how long after expiry a token can still be refreshed
+func refreshGrace() time.Duration {
+
+	cfg := GetConfigManager().serverConfig()
+
+	if cfg.Auth.RefreshGrace > 0 {
+		return time.Duration(cfg.Auth.RefreshGrace) * time.Minute
+	}
+
+	return defaultRefreshGrace
+}
+
+// refreshExpiration returns the configured lifetime of a refresh token
+func refreshExpiration() time.Duration {
+
+	cfg := GetConfigManager().serverConfig()
+
+	if cfg.Auth.RefreshExpiration > 0 {
+		return time.Duration(cfg.Auth.RefreshExpiration) * time.Minute
+	}
+
+	return defaultRefreshExpiration
+}
+
+// randomString returns a url safe random string of n bytes
+func randomString(n int) (string, error) {
+
+	buf := make([]byte, n)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// bearerToken extract the token from the authorization header
+func bearerToken(r *http.Request) string {
+
+	header := r.Header.Get("Authorization")
+
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	return ""
+}
+
+// issueToken sign a new token for the given claims
+func (auth *AuthManager) issueToken(claims *types.CyberoClaims) (string, time.Time, error) {
+
+	if auth.keys == nil {
+		return "", time.Time{}, errors.New("no signing key available")
+	}
+
+	id, err := randomString(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(tokenExpiration())
+
+	claims.Id = id
+	claims.Issuer = TokenIssuer
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = expirationTime.Unix()
+
+	tokenString, err := auth.keys.sign(claims)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expirationTime, nil
+}
+
+// parseToken validate a signed token and return its claims, claims are
+// returned even if validation fails so callers can inspect them
+func (auth *AuthManager) parseToken(tokenString string) (*types.CyberoClaims, error) {
+
+	claims := &types.CyberoClaims{}
+
+	if auth.keys == nil {
+		return claims, errors.New("no verification key available")
+	}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, auth.keys.keyFunc)
+
+	if err != nil {
+		return claims, err
+	}
+
+	if claims.Issuer != TokenIssuer {
+		return claims, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+
+	return claims, nil
+}
+
+// tokenIssuer returns the unverified issuer of a token
+func tokenIssuer(tokenString string) string {
+
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{}
+
+	if _, _, err := parser.ParseUnverified(tokenString, claims); err != nil {
+		return ""
+	}
+
+	issuer, _ := claims["iss"].(string)
+	return issuer
+}
+
+// isExpiredOnly returns true if the validation only failed due to expiry
+func isExpiredOnly(err error) bool {
+
+	if verr, ok := err.(*jwt.ValidationError); ok {
+		return verr.Errors == jwt.ValidationErrorExpired
+	}
+
+	return false
+}
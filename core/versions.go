@@ -0,0 +1,204 @@
+// Copyright 2020 Alexandre Pires (c.alexandre.pires@gmail.com)
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// APIVersionHeader the header selecting the major version of a module,
+// /api/vN/module does the same
+const APIVersionHeader = "X-Cybero-Version"
+
+var (
+	errModuleNotFound  = errors.New("module not found")
+	errVersionNotFound = errors.New("module version not available")
+)
+
+// apiRoute the module version serving a request
+type apiRoute struct {
+	handle    *moduleHandle
+	endpoint  string
+	disabled  bool
+	successor string
+}
+
+// parseAPIVersion parse a major version, "v2" or "2"
+func parseAPIVersion(version string) (int, bool) {
+
+	major, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if err != nil || major < 0 {
+		return 0, false
+	}
+
+	return major, true
+}
+
+// majorVersion returns the major of a module version, -1 if invalid
+func majorVersion(version string) int {
+
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return -1
+	}
+
+	return parsed.major
+}
+
+// versionSlot the slot of a plugin version, side by side versions of a
+// module have a slot per configured major version
+func versionSlot(name string, version string) (string, bool) {
+
+	config, _ := GetConfigManager().moduleConfig(name)
+
+	versions := config.Versions
+	if len(versions) == 0 {
+		return "", true
+	}
+
+	major := majorVersion(version)
+
+	for _, allowed := range versions {
+		if configured, ok := parseAPIVersion(allowed); ok && configured == major {
+			return fmt.Sprintf("v%d", major), true
+		}
+	}
+
+	return "", false
+}
+
+// addAPIHandle serve a module version on an endpoint, versions are kept
+// newest first. Must be called locked
+func (mod *ModulesManager) addAPIHandle(endpoint string, handle *moduleHandle) {
+
+	handles := mod.apiModules[endpoint]
+
+	index := 0
+	for index < len(handles) && compareVersions(handles[index].version, handle.version) > 0 {
+		index++
+	}
+
+	handles = append(handles, nil)
+	copy(handles[index+1:], handles[index:])
+	handles[index] = handle
+
+	mod.apiModules[endpoint] = handles
+}
+
+// defaultHandle returns the version served when none is asked, the
+// configured default version or the newest. Must be called locked
+func (mod *ModulesManager) defaultHandle(handles []*moduleHandle) *moduleHandle {
+
+	config, _ := GetConfigManager().moduleConfig(handles[0].name)
+
+	if major, ok := parseAPIVersion(config.DefaultVersion); ok {
+		for _, handle := range handles {
+			if majorVersion(handle.version) == major {
+				return handle
+			}
+		}
+	}
+
+	return handles[0]
+}
+
+// selectHandle returns the newest version of a module with the major
+// version asked, the default version if none. Must be called locked
+func (mod *ModulesManager) selectHandle(endpoint string, version string) (*moduleHandle, error) {
+
+	handles, ok := mod.apiModules[endpoint]
+	if !ok {
+		return nil, errModuleNotFound
+	}
+
+	if version == "" {
+		return mod.defaultHandle(handles), nil
+	}
+
+	if major, ok := parseAPIVersion(version); ok {
+		for _, handle := range handles {
+			if majorVersion(handle.version) == major {
+				return handle, nil
+			}
+		}
+	}
+
+	return nil, errVersionNotFound
+}
+
+// versionMatrix returns the versions loaded of the module on an endpoint
+func (mod *ModulesManager) versionMatrix(endpoint string) []map[string]interface{} {
+
+	mod.RLock()
+	defer mod.RUnlock()
+
+	handles := mod.apiModules[endpoint]
+	if len(handles) == 0 {
+		return nil
+	}
+
+	defaultHandle := mod.defaultHandle(handles)
+	matrix := []map[string]interface{}{}
+
+	for _, handle := range handles {
+		matrix = append(matrix, map[string]interface{}{
+			"version":    handle.version,
+			"default":    handle == defaultHandle,
+			"deprecated": majorVersion(handle.version) < majorVersion(handles[0].version),
+			"enabled":    !handle.disabled,
+			"breaker":    handle.breaker.state(),
+		})
+	}
+
+	return matrix
+}
+
+// acquireAPIModule get the module version serving an endpoint, the route
+// handle must be released once the request finishes. Versions older than
+// the newest major have the newest major as successor
+func (mod *ModulesManager) acquireAPIModule(endpoint string, version string) (apiRoute, error) {
+
+	mod.RLock()
+	defer mod.RUnlock()
+
+	handle, err := mod.selectHandle(endpoint, version)
+	if err != nil {
+		return apiRoute{}, err
+	}
+
+	route := apiRoute{handle: handle, endpoint: endpoint, disabled: handle.disabled}
+
+	// Requests to a disabled module are not run, so are not waited for
+	if !route.disabled {
+		handle.requests.Add(1)
+	}
+
+	newest := mod.apiModules[endpoint][0]
+	if majorVersion(handle.version) < majorVersion(newest.version) {
+		route.successor = fmt.Sprintf("v%d", majorVersion(newest.version))
+	}
+
+	return route, nil
+}
+
+// release signal a request using the route finished
+func (route apiRoute) release() {
+	if !route.disabled {
+		route.handle.release()
+	}
+}
@@ -15,6 +15,7 @@
 package types
 
 import (
+	"context"
 	"log"
 	"net/http"
 
@@ -45,6 +46,64 @@ type CyberoAuthModule interface {
 	Authenticate(*CyberoCredentials) bool
 }
 
+// CyberoAuthRolesModule an authentication module that also provides
+// the roles of the authenticated users
+type CyberoAuthRolesModule interface {
+	CyberoAuthModule
+	Roles(string) []string
+}
+
+// CyberoDependentModule a module requiring other modules to be
+// initialized first, maps the module names to a semantic version range
+type CyberoDependentModule interface {
+	Dependencies() map[string]string
+}
+
+// CyberoServiceRegistry shares services, usually Go interfaces, between
+// modules. Lookup sets target, a pointer, to the service published
+type CyberoServiceRegistry interface {
+	Publish(name string, service interface{}) error
+	Lookup(name string, target interface{}) error
+}
+
+// CyberoServiceModule a module using the service registry, the registry is
+// set before the module is initialized
+type CyberoServiceModule interface {
+	SetServices(CyberoServiceRegistry)
+}
+
+// CyberoModuleContext the part of the server a module can access, its own
+// configuration, a logger, a data directory and the service registry
+type CyberoModuleContext interface {
+	Name() string
+	Config() map[string]interface{}
+	Logger() *log.Logger
+	DataDir() string
+	Services() CyberoServiceRegistry
+}
+
+// CyberoContextModule a module initialized with its context, called
+// instead of Initialize
+type CyberoContextModule interface {
+	InitializeContext(CyberoModuleContext) error
+}
+
+// CyberoStartModule a module with work to start once initialized, a
+// module failing to start is not made available
+type CyberoStartModule interface {
+	Start() error
+}
+
+// CyberoShutdownModule a module with resources to release on shutdown
+type CyberoShutdownModule interface {
+	Shutdown(context.Context) error
+}
+
+// CyberoHealthModule a module able to report its health
+type CyberoHealthModule interface {
+	Health() error
+}
+
 // CyberoResponse represents a outgoing response
 type CyberoResponse map[string]interface{}
 
@@ -56,43 +115,172 @@ type CyberoEndpoints map[string]CyberoHandler
 
 // CyberoModuleConfig configuration of a module
 type CyberoModuleConfig struct {
-	Enabled bool                   `json:"enabled"`
-	Config  map[string]interface{} `json:"config"`
+	Enabled        bool                   `json:"enabled"`
+	Public         bool                   `json:"public"`
+	Kind           string                 `json:"kind,omitempty"`
+	Endpoint       string                 `json:"endpoint,omitempty"`
+	Versions       []string               `json:"versions,omitempty"`
+	DefaultVersion string                 `json:"defaultversion,omitempty"`
+	Command        string                 `json:"command,omitempty"`
+	Args           []string               `json:"args,omitempty"`
+	Config         map[string]interface{} `json:"config"`
 }
 
 // CyberoModulesConfig config part of modules
 type CyberoModulesConfig struct {
-	Path          string                        `json:"path"`
-	Configuration map[string]CyberoModuleConfig `json:"configs"`
+	Path             string                        `json:"path"`
+	DataPath         string                        `json:"datapath"`
+	Manifest         string                        `json:"manifest"`
+	PublicKeys       []string                      `json:"publickeys"`
+	Strict           bool                          `json:"strict"`
+	ConflictPolicy   string                        `json:"conflictpolicy"`
+	BreakerThreshold int                           `json:"breakerthreshold"`
+	BreakerCooldown  int                           `json:"breakercooldown"`
+	Configuration    map[string]CyberoModuleConfig `json:"configs"`
+}
+
+// CyberoAuthKeyConfig a key pair used to sign and verify tokens, keys
+// without a private key are only used to verify tokens
+type CyberoAuthKeyConfig struct {
+	ID         string `json:"kid"`
+	Algorithm  string `json:"algorithm"`
+	PrivateKey string `json:"privatekey"`
+	PublicKey  string `json:"publickey"`
+	Signing    bool   `json:"signing"`
+}
+
+// CyberoPeerIdentity identity given to a local peer
+type CyberoPeerIdentity struct {
+	Username string   `json:"username"`
+	Roles    []string `json:"roles"`
+}
+
+// CyberoPeerCredConfig config part of unix socket peer authentication,
+// uids and gids allowed to connect map to the identity they are given
+type CyberoPeerCredConfig struct {
+	Enabled bool                          `json:"enabled"`
+	Uids    map[string]CyberoPeerIdentity `json:"uids"`
+	Gids    map[string]CyberoPeerIdentity `json:"gids"`
+}
+
+// CyberoOIDCConfig config part of OpenID Connect token validation, keys
+// are loaded from JWKSFile, JWKSURL or the issuer discovery document
+type CyberoOIDCConfig struct {
+	Issuer          string `json:"issuer"`
+	Discovery       string `json:"discovery"`
+	JWKSURL         string `json:"jwksurl"`
+	JWKSFile        string `json:"jwksfile"`
+	Audience        string `json:"audience"`
+	UsernameClaim   string `json:"usernameclaim"`
+	RolesClaim      string `json:"rolesclaim"`
+	RefreshInterval int    `json:"refreshinterval"`
+}
+
+// CyberoLockoutConfig config part of signin brute force protection, Backoff
+// is in seconds and Duration in minutes
+type CyberoLockoutConfig struct {
+	MaxFailures        int `json:"maxfailures"`
+	MaxAddressFailures int `json:"maxaddressfailures"`
+	Backoff            int `json:"backoff"`
+	Duration           int `json:"duration"`
 }
 
 // CyberoAuthConfig config part of authentication
 type CyberoAuthConfig struct {
-	Path     string                 `json:"path"`
-	Provider string                 `json:"provider"`
-	Config   map[string]interface{} `json:"config"`
-	Secret   []byte                 `json:"secret"`
+	Path              string                 `json:"path"`
+	Provider          string                 `json:"provider"`
+	Providers         []string               `json:"providers"`
+	Config            map[string]interface{} `json:"config"`
+	Secret            []byte                 `json:"secret"`
+	Keys              []CyberoAuthKeyConfig  `json:"keys"`
+	Expiration        int                    `json:"expiration"`
+	RefreshGrace      int                    `json:"refreshgrace"`
+	RefreshExpiration int                    `json:"refreshexpiration"`
+	RevocationFile    string                 `json:"revocationfile"`
+	KeysFile          string                 `json:"keysfile"`
+	PeerCred          CyberoPeerCredConfig   `json:"peercred"`
+	OIDC              CyberoOIDCConfig       `json:"oidc"`
+	Lockout           CyberoLockoutConfig    `json:"lockout"`
+}
+
+// CyberoPolicyConfig config part of authorization, roles map to the scopes
+// they grant, users to their roles and "module/action" or "module" to the
+// scopes required to call them
+type CyberoPolicyConfig struct {
+	Roles   map[string][]string `json:"roles"`
+	Users   map[string][]string `json:"users"`
+	Actions map[string][]string `json:"actions"`
 }
 
 // CyberoServerConfig The server configuration structure
 type CyberoServerConfig struct {
-	Socket  string              `json:"socket"`
-	TLS     bool                `json:"tls"`
-	CertPEM string              `json:"certpem"`
-	CertKey string              `json:"certkey"`
-	LogFile string              `json:"logfile"`
-	Modules CyberoModulesConfig `json:"modules"`
-	Auth    CyberoAuthConfig    `json:"auth"`
+	Socket     string              `json:"socket"`
+	TLS        bool                `json:"tls"`
+	CertPEM    string              `json:"certpem"`
+	CertKey    string              `json:"certkey"`
+	ClientCA   string              `json:"clientca"`
+	ClientAuth string              `json:"clientauth"`
+	LogFile    string              `json:"logfile"`
+	Modules    CyberoModulesConfig `json:"modules"`
+	Auth       CyberoAuthConfig    `json:"auth"`
+	Policy     CyberoPolicyConfig  `json:"policy"`
 }
 
 // CyberoCredentials json signin structure
 type CyberoCredentials struct {
 	Password string `json:"password"`
 	Username string `json:"username"`
+	Provider string `json:"provider"`
+}
+
+// CyberoRefreshRequest json refresh structure
+type CyberoRefreshRequest struct {
+	RefreshToken string `json:"refreshtoken"`
+}
+
+// CyberoAPIKey a long lived API key, only the hash of the key is kept
+type CyberoAPIKey struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Owner   string   `json:"owner"`
+	Scopes  []string `json:"scopes"`
+	Created int64    `json:"created"`
+	Expires int64    `json:"expires"`
+	Hash    string   `json:"hash,omitempty"`
+}
+
+// CyberoAPIKeyRequest json API key management structure
+type CyberoAPIKeyRequest struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Owner   string   `json:"owner"`
+	Scopes  []string `json:"scopes"`
+	Expires int64    `json:"expires"`
+}
+
+// CyberoUnlockRequest json unlock structure
+type CyberoUnlockRequest struct {
+	Username string `json:"username"`
+	Address  string `json:"address"`
 }
 
 // CyberoClaims  json claim structure
 type CyberoClaims struct {
-	Username string `json:"username"`
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+	Provider string   `json:"provider,omitempty"`
 	jwt.StandardClaims
 }
+
+// CyberoContextKey type of the keys stored by Cybero in a request context
+type CyberoContextKey string
+
+// CyberoClaimsKey context key of the authenticated claims
+const CyberoClaimsKey CyberoContextKey = "claims"
+
+// GetClaims returns the authenticated claims stored in the context
+func GetClaims(ctx context.Context) (*CyberoClaims, bool) {
+	claims, ok := ctx.Value(CyberoClaimsKey).(*CyberoClaims)
+	return claims, ok
+}